@@ -0,0 +1,244 @@
+package gobaresip
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Account describes a baresip User-Agent account.
+type Account struct {
+	// DisplayName is shown to the called party.
+	DisplayName string `json:"displayname,omitempty"`
+	// AOR is the address of record, e.g. sip:alice@example.com.
+	AOR string `json:"aor"`
+	// Transport is one of udp, tcp or tls.
+	Transport string `json:"transport,omitempty"`
+
+	AuthUser string `json:"auth_user,omitempty"`
+	AuthPass string `json:"auth_pass,omitempty"`
+	// Outbound is the outbound proxy, e.g. sip:proxy.example.com;transport=tcp.
+	Outbound string `json:"outbound,omitempty"`
+	// RegInt is the registration interval in seconds. 0 uses baresip's
+	// default and -1 disables registration.
+	RegInt int `json:"regint,omitempty"`
+	// MediaEnc is one of srtp, srtp-mand, srtp-mandf, dtls_srtp or zrtp.
+	MediaEnc string `json:"mediaenc,omitempty"`
+	// AudioCodecs limits and orders the audio codecs, e.g. opus/48000/2, pcma.
+	AudioCodecs []string `json:"audio_codecs,omitempty"`
+	// AnswerMode is one of manual, early or auto.
+	AnswerMode string `json:"answermode,omitempty"`
+	// STUNServer has the form stun:[user:pass]@host[:port].
+	STUNServer string `json:"stunserver,omitempty"`
+	STUNUser   string `json:"stunuser,omitempty"`
+	STUNPass   string `json:"stunpass,omitempty"`
+	// MWI enables the subscription for message waiting indication.
+	MWI bool `json:"mwi,omitempty"`
+
+	// Params holds additional account parameters.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// aor returns the AOR with sip: as default scheme.
+func (a Account) aor() string {
+	if strings.HasPrefix(a.AOR, "sip:") || strings.HasPrefix(a.AOR, "sips:") {
+		return a.AOR
+	}
+	return "sip:" + a.AOR
+}
+
+// Line renders the account in the syntax of baresip's accounts file and uanew.
+func (a Account) Line() (string, error) {
+	if a.AOR == "" {
+		return "", fmt.Errorf("account without aor")
+	}
+	if strings.ContainsAny(a.AOR, "<>\"; \t\r\n") {
+		return "", fmt.Errorf("invalid aor %q", a.AOR)
+	}
+
+	var sb strings.Builder
+	if a.DisplayName != "" {
+		if strings.ContainsAny(a.DisplayName, "\"\r\n") {
+			return "", fmt.Errorf("invalid display name %q", a.DisplayName)
+		}
+		sb.WriteString(`"` + a.DisplayName + `" `)
+	}
+
+	sb.WriteString("<" + a.aor())
+	if a.Transport != "" {
+		if err := writeParam(&sb, "transport", a.Transport); err != nil {
+			return "", err
+		}
+	}
+	sb.WriteString(">")
+
+	params := [][2]string{
+		{"auth_user", a.AuthUser},
+		{"auth_pass", a.AuthPass},
+		{"outbound", a.Outbound},
+		{"mediaenc", a.MediaEnc},
+		{"audio_codecs", joinCodecs(a.AudioCodecs)},
+		{"answermode", a.AnswerMode},
+		{"stunserver", a.STUNServer},
+		{"stunuser", a.STUNUser},
+		{"stunpass", a.STUNPass},
+	}
+	switch {
+	case a.RegInt > 0:
+		params = append(params, [2]string{"regint", strconv.Itoa(a.RegInt)})
+	case a.RegInt < 0:
+		params = append(params, [2]string{"regint", "0"})
+	}
+	if a.MWI {
+		params = append(params, [2]string{"mwi", "yes"})
+	} else {
+		params = append(params, [2]string{"mwi", "no"})
+	}
+
+	keys := make([]string, 0, len(a.Params))
+	for k := range a.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, [2]string{k, a.Params[k]})
+	}
+
+	for _, p := range params {
+		if p[1] == "" {
+			continue
+		}
+		if err := writeParam(&sb, p[0], p[1]); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+func joinCodecs(codecs []string) string {
+	out := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// writeParam appends ;key=value and quotes value if it contains separators.
+// baresip keeps the quotes of a value, so comma lists like audio_codecs are
+// left unquoted.
+func writeParam(sb *strings.Builder, key, value string) error {
+	if key == "" || strings.ContainsAny(key, "<>\";=, \t\r\n") {
+		return fmt.Errorf("invalid account parameter name %q", key)
+	}
+	if strings.ContainsAny(value, "\"\r\n") {
+		return fmt.Errorf("invalid value for account parameter %s", key)
+	}
+
+	sb.WriteString(";" + key + "=")
+	if strings.ContainsAny(value, "<>; \t") {
+		sb.WriteString(`"` + value + `"`)
+	} else {
+		sb.WriteString(value)
+	}
+	return nil
+}
+
+// accountRestoreTimeout limits restoring an account after UpdateAccount
+// failed.
+const accountRestoreTimeout = 5 * time.Second
+
+// accountList holds the accounts added through AddAccount.
+type accountList struct {
+	mux sync.RWMutex
+	m   map[string]Account
+}
+
+// AddAccount will create a User-Agent for a.
+func (b *Baresip) AddAccount(ctx context.Context, a Account) error {
+	line, err := a.Line()
+	if err != nil {
+		return err
+	}
+	return b.addAccount(ctx, a, line)
+}
+
+func (b *Baresip) addAccount(ctx context.Context, a Account, line string) error {
+	if _, err := b.Do(ctx, "uanew", line); err != nil {
+		return err
+	}
+
+	b.accounts.mux.Lock()
+	b.accounts.m[a.aor()] = a
+	b.accounts.mux.Unlock()
+	return nil
+}
+
+// RemoveAccount will delete the User-Agent with aor.
+func (b *Baresip) RemoveAccount(ctx context.Context, aor string) error {
+	aor = Account{AOR: aor}.aor()
+	if _, err := b.Do(ctx, "uadel", aor); err != nil {
+		return err
+	}
+
+	b.accounts.mux.Lock()
+	delete(b.accounts.m, aor)
+	b.accounts.mux.Unlock()
+
+	b.removeRegistration(aor)
+	return nil
+}
+
+// UpdateAccount will replace the User-Agent with the AOR of a. If the new
+// User-Agent can't be created, the previous one is restored if it was added
+// through AddAccount.
+func (b *Baresip) UpdateAccount(ctx context.Context, a Account) error {
+	line, err := a.Line()
+	if err != nil {
+		return err
+	}
+
+	b.accounts.mux.RLock()
+	old, known := b.accounts.m[a.aor()]
+	b.accounts.mux.RUnlock()
+	oldLine, err := old.Line()
+	known = known && err == nil
+
+	if err := b.RemoveAccount(ctx, a.AOR); err != nil {
+		return err
+	}
+	if err := b.addAccount(ctx, a, line); err != nil {
+		if known {
+			// ctx may be the reason of the failure, restore with a fresh one.
+			rctx, cancel := b.quitContext()
+			defer cancel()
+			rctx, tcancel := context.WithTimeout(rctx, accountRestoreTimeout)
+			defer tcancel()
+			if rerr := b.addAccount(rctx, old, oldLine); rerr != nil {
+				return fmt.Errorf("%v, restoring previous account failed: %v", err, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Accounts returns the accounts added through AddAccount ordered by AOR.
+func (b *Baresip) Accounts() []Account {
+	b.accounts.mux.RLock()
+	accs := make([]Account, 0, len(b.accounts.m))
+	for _, a := range b.accounts.m {
+		accs = append(accs, a)
+	}
+	b.accounts.mux.RUnlock()
+
+	sort.Slice(accs, func(i, j int) bool {
+		return accs[i].aor() < accs[j].aor()
+	})
+	return accs
+}
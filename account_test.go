@@ -0,0 +1,79 @@
+package gobaresip
+
+import "testing"
+
+func TestAccountLine(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Account
+		want string
+	}{
+		{
+			name: "minimal",
+			a:    Account{AOR: "alice@example.com"},
+			want: "<sip:alice@example.com>;mwi=no",
+		},
+		{
+			name: "full",
+			a: Account{
+				DisplayName: "Alice",
+				AOR:         "sips:alice@example.com",
+				Transport:   "tls",
+				AuthUser:    "alice",
+				AuthPass:    "secret",
+				Outbound:    "sip:proxy.example.com;transport=tcp",
+				RegInt:      600,
+				AudioCodecs: []string{"PCMU", " opus/48000/2"},
+				MWI:         true,
+				Params:      map[string]string{"sipnat": "outbound", "call_transfer": "no"},
+			},
+			want: `"Alice" <sips:alice@example.com;transport=tls>` +
+				`;auth_user=alice;auth_pass=secret` +
+				`;outbound="sip:proxy.example.com;transport=tcp"` +
+				`;audio_codecs=PCMU,opus/48000/2;regint=600;mwi=yes` +
+				`;call_transfer=no;sipnat=outbound`,
+		},
+		{
+			name: "registration disabled",
+			a:    Account{AOR: "sip:bob@example.com", RegInt: -1},
+			want: "<sip:bob@example.com>;regint=0;mwi=no",
+		},
+		{
+			name: "whitespace and brackets are quoted",
+			a: Account{
+				AOR:      "bob@example.com",
+				AuthPass: "two words",
+				Params:   map[string]string{"x": "<y>"},
+			},
+			want: `<sip:bob@example.com>;auth_pass="two words";mwi=no;x="<y>"`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Line()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAccountLineInvalid(t *testing.T) {
+	tests := []Account{
+		{},
+		{AOR: "alice@example.com>"},
+		{AOR: "alice@example.com;x=y"},
+		{AOR: "alice@example.com", DisplayName: `A "B"`},
+		{AOR: "alice@example.com", AuthPass: `se"cret`},
+		{AOR: "alice@example.com", AuthPass: "a\nb"},
+		{AOR: "alice@example.com", Params: map[string]string{"a=b": "c"}},
+		{AOR: "alice@example.com", Params: map[string]string{"": "c"}},
+	}
+	for _, a := range tests {
+		if line, err := a.Line(); err == nil {
+			t.Errorf("%+v: got %s, want error", a, line)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package gobaresip
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCall is returned by the methods of a Call which wasn't returned by
+// Baresip, e.g. the zero Call of a failed lookup.
+var ErrNoCall = errors.New("no such call")
+
+// closedCallRetention is how long Call still returns a closed call.
+const closedCallRetention = time.Minute
+
+// lostCallReason is the CloseReason of calls which baresip didn't list
+// anymore after ctrl_tcp came back.
+const lostCallReason = "lost while ctrl_tcp was down"
+
+// CallState is the state of a call as reported by baresip events.
+type CallState int
+
+const (
+	CallStateIncoming CallState = iota
+	CallStateOutgoing
+	CallStateRinging
+	CallStateProgress
+	CallStateEstablished
+	CallStateClosed
+)
+
+var callStateNames = [...]string{
+	CallStateIncoming:    "incoming",
+	CallStateOutgoing:    "outgoing",
+	CallStateRinging:     "ringing",
+	CallStateProgress:    "progress",
+	CallStateEstablished: "established",
+	CallStateClosed:      "closed",
+}
+
+func (s CallState) String() string {
+	if s >= 0 && int(s) < len(callStateNames) {
+		return callStateNames[s]
+	}
+	return "unknown"
+}
+
+// Call is a snapshot of a call tracked by the CallRegistry.
+type Call struct {
+	ID              string
+	AccountAOR      string
+	PeerURI         string
+	PeerDisplayname string
+	Direction       string
+	State           CallState
+	CloseReason     string
+
+	Created     time.Time
+	Established time.Time
+	Closed      time.Time
+	Updated     time.Time
+
+	b *Baresip
+}
+
+// CallRegistry tracks the lifecycle of every call from baresip's events.
+type CallRegistry struct {
+	b     *Baresip
+	mux   sync.RWMutex
+	calls map[string]*Call
+	hooks []func(c Call, prev CallState)
+}
+
+func newCallRegistry(b *Baresip) *CallRegistry {
+	return &CallRegistry{b: b, calls: make(map[string]*Call)}
+}
+
+// Calls returns all active calls ordered by creation time.
+func (r *CallRegistry) Calls() []Call {
+	r.mux.RLock()
+	calls := make([]Call, 0, len(r.calls))
+	for _, c := range r.calls {
+		if c.State != CallStateClosed {
+			calls = append(calls, *c)
+		}
+	}
+	r.mux.RUnlock()
+
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Created.Before(calls[j].Created)
+	})
+	return calls
+}
+
+// Call returns the call with the given Call-ID. Closed calls are kept for
+// a minute, so their state and CloseReason can still be looked up.
+func (r *CallRegistry) Call(id string) (Call, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if c, ok := r.calls[id]; ok && !c.expired(time.Now()) {
+		return *c, true
+	}
+	return Call{}, false
+}
+
+func (c *Call) expired(now time.Time) bool {
+	return c.State == CallStateClosed && now.Sub(c.Closed) > closedCallRetention
+}
+
+// prune must be called with mux held.
+func (r *CallRegistry) prune(now time.Time) {
+	for id, c := range r.calls {
+		if c.expired(now) {
+			delete(r.calls, id)
+		}
+	}
+}
+
+// OnCallStateChange registers f to be called whenever a call changes its state.
+// A new call reports its first state with prev equal to its current state.
+// The hooks run in order on their own goroutine, so f may run commands, but
+// a slow f delays the following hooks.
+func (r *CallRegistry) OnCallStateChange(f func(c Call, prev CallState)) {
+	r.mux.Lock()
+	r.hooks = append(r.hooks, f)
+	r.mux.Unlock()
+}
+
+// update applies a call event to the registry. Closed calls ignore further
+// events and are removed after closedCallRetention.
+func (r *CallRegistry) update(e EventMsg) {
+	if e.ID == "" {
+		return
+	}
+
+	var state CallState
+	switch e.EventType {
+	case EventCallIncoming:
+		state = CallStateIncoming
+	case EventCallOutgoing:
+		state = CallStateOutgoing
+	case EventCallRinging:
+		state = CallStateRinging
+	case EventCallProgress:
+		state = CallStateProgress
+	case EventCallEstablished:
+		state = CallStateEstablished
+	case EventCallClosed:
+		state = CallStateClosed
+	default:
+		return
+	}
+
+	now := time.Now()
+
+	r.mux.Lock()
+	r.prune(now)
+	c, ok := r.calls[e.ID]
+	if ok && c.State == CallStateClosed {
+		r.mux.Unlock()
+		return
+	}
+	if !ok {
+		c = &Call{ID: e.ID, State: state, Created: now, b: r.b}
+		r.calls[e.ID] = c
+	}
+	prev := c.State
+
+	if e.AccountAOR != "" {
+		c.AccountAOR = e.AccountAOR
+	}
+	if e.PeerURI != "" {
+		c.PeerURI = e.PeerURI
+	}
+	if e.PeerDisplayname != "" {
+		c.PeerDisplayname = e.PeerDisplayname
+	}
+	if e.Direction != "" {
+		c.Direction = e.Direction
+	}
+
+	c.State = state
+	c.Updated = now
+	switch state {
+	case CallStateEstablished:
+		c.Established = now
+	case CallStateClosed:
+		c.Closed = now
+		c.CloseReason = e.Param
+	}
+
+	snap := *c
+	hooks := r.hooks
+	r.mux.Unlock()
+
+	if ok && prev == state {
+		return
+	}
+	r.b.hookq.push(func() {
+		for _, f := range hooks {
+			f(snap, prev)
+		}
+	})
+}
+
+// closeMissing closes the active calls which were not updated since before
+// and are not in ids.
+func (r *CallRegistry) closeMissing(ids map[string]bool, before time.Time) {
+	now := time.Now()
+
+	r.mux.Lock()
+	var lost []Call
+	var prev []CallState
+	for id, c := range r.calls {
+		if c.State == CallStateClosed || ids[id] || !c.Updated.Before(before) {
+			continue
+		}
+		prev = append(prev, c.State)
+		c.State = CallStateClosed
+		c.Closed = now
+		c.Updated = now
+		c.CloseReason = lostCallReason
+		lost = append(lost, *c)
+	}
+	hooks := r.hooks
+	r.mux.Unlock()
+
+	if len(lost) == 0 {
+		return
+	}
+	r.b.hookq.push(func() {
+		for i, c := range lost {
+			for _, f := range hooks {
+				f(c, prev[i])
+			}
+		}
+	})
+}
+
+var (
+	listCallsID    = regexp.MustCompile(`\[line \d+, id ([^\]]+)\]`)
+	listCallsCount = regexp.MustCompile(`Active calls \((\d+)\)`)
+)
+
+// parseListCalls returns the Call-IDs in the output of listcalls. ok is false
+// if the output is not understood.
+func parseListCalls(data string) (ids map[string]bool, ok bool) {
+	ids = make(map[string]bool)
+	if strings.Contains(strings.ToLower(data), "no active calls") {
+		return ids, true
+	}
+	m := listCallsCount.FindStringSubmatch(data)
+	if m == nil {
+		return nil, false
+	}
+	for _, id := range listCallsID.FindAllStringSubmatch(data, -1) {
+		ids[id[1]] = true
+	}
+	if n, _ := strconv.Atoi(m[1]); n != len(ids) {
+		return nil, false
+	}
+	return ids, true
+}
+
+// resyncCalls closes the calls whose CALL_CLOSED got lost while ctrl_tcp was
+// down, so they don't stay active forever.
+func (b *Baresip) resyncCalls() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := time.Now()
+	r, err := b.do(ctx, "listcalls", "")
+	if err != nil {
+		b.log(LevelWarn, "can't resync calls", LogAttr{Key: "error", Value: err})
+		return
+	}
+	ids, ok := parseListCalls(r.Data)
+	if !ok {
+		b.log(LevelWarn, "can't resync calls", LogAttr{Key: "listcalls", Value: r.Data})
+		return
+	}
+	b.calls.closeMissing(ids, before)
+}
+
+// Calls returns all active calls.
+func (b *Baresip) Calls() []Call {
+	return b.calls.Calls()
+}
+
+// Call returns the call with the given Call-ID, closed calls are kept for
+// a minute.
+func (b *Baresip) Call(id string) (Call, bool) {
+	return b.calls.Call(id)
+}
+
+// OnCallStateChange registers f to be called whenever a call changes its state.
+func (b *Baresip) OnCallStateChange(f func(c Call, prev CallState)) {
+	b.calls.OnCallStateChange(f)
+}
+
+// Dial will call uri and return the ID of the new call, which is empty if
+// baresip didn't report it. If aor is not empty, the call is made from that
+// User-Agent instead of the current one.
+func (b *Baresip) Dial(ctx context.Context, aor, uri string) (string, error) {
+	b.lineMux.Lock()
+	defer b.lineMux.Unlock()
+
+	if aor != "" {
+		if _, err := b.Do(ctx, "uafind", Account{AOR: aor}.aor()); err != nil {
+			return "", err
+		}
+	}
+	r, err := b.Do(ctx, "dial", uri)
+	if err != nil {
+		return "", err
+	}
+	return findID([]byte(r.Data)), nil
+}
+
+// cmd runs command with CallCmd for the call.
+func (c Call) cmd(ctx context.Context, command, params string) error {
+	if c.b == nil {
+		return ErrNoCall
+	}
+	_, err := c.b.CallCmd(ctx, c.ID, command, params)
+	return err
+}
+
+// Answer will accept the incoming call.
+func (c Call) Answer(ctx context.Context) error {
+	return c.cmd(ctx, "accept", "")
+}
+
+// AnswerDir will accept the incoming call with audio and videodirection.
+func (c Call) AnswerDir(ctx context.Context, dir string) error {
+	return c.cmd(ctx, "acceptdir", dir)
+}
+
+// Hangup will hangup the call.
+func (c Call) Hangup(ctx context.Context) error {
+	if c.b == nil {
+		return ErrNoCall
+	}
+	_, err := c.b.Do(ctx, "hangup", c.ID)
+	return err
+}
+
+// Hold will put the call on hold.
+func (c Call) Hold(ctx context.Context) error {
+	return c.cmd(ctx, "hold", "")
+}
+
+// Resume will resume the call from hold.
+func (c Call) Resume(ctx context.Context) error {
+	return c.cmd(ctx, "resume", "")
+}
+
+// Mute will toggle mute of the call.
+func (c Call) Mute(ctx context.Context) error {
+	return c.cmd(ctx, "mute", "")
+}
+
+// Reinvite will send a re-INVITE for the call.
+func (c Call) Reinvite(ctx context.Context) error {
+	return c.cmd(ctx, "reinvite", "")
+}
+
+// Transfer will transfer the call to uri.
+func (c Call) Transfer(ctx context.Context, uri string) error {
+	return c.cmd(ctx, "transfer", uri)
+}
+
+// SendDTMF will send the DTMF digits to the call.
+func (c Call) SendDTMF(ctx context.Context, digits string) error {
+	return c.cmd(ctx, "sndcode", digits)
+}
+
+// SetMediaDir will set the local media direction of the call,
+// e.g. "audio=sendonly".
+func (c Call) SetMediaDir(ctx context.Context, dir string) error {
+	return c.cmd(ctx, "medialdir", dir)
+}
@@ -0,0 +1,140 @@
+package gobaresip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCallHooksRunOffTheReader(t *testing.T) {
+	b := newBaresip()
+	release := make(chan struct{})
+	got := make(chan CallState, 4)
+	b.OnCallStateChange(func(c Call, prev CallState) {
+		<-release
+		got <- c.State
+	})
+
+	// update must not wait for the blocked hook.
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallOutgoing})
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallEstablished})
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go b.hookq.run(quit)
+	close(release)
+
+	for _, want := range []CallState{CallStateOutgoing, CallStateEstablished} {
+		select {
+		case s := <-got:
+			if s != want {
+				t.Errorf("got %v, want %v", s, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("hook was not called")
+		}
+	}
+}
+
+func TestClosedCallIsKept(t *testing.T) {
+	b := newBaresip()
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallIncoming})
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallClosed, Param: "Connection reset by peer"})
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallRinging})
+
+	if n := len(b.Calls()); n != 0 {
+		t.Errorf("got %d active calls, want 0", n)
+	}
+	c, ok := b.Call("c1")
+	if !ok || c.State != CallStateClosed || c.CloseReason != "Connection reset by peer" {
+		t.Fatalf("got %+v, %v", c, ok)
+	}
+
+	b.calls.calls["c1"].Closed = time.Now().Add(-2 * closedCallRetention)
+	if _, ok := b.Call("c1"); ok {
+		t.Error("expired call still returned")
+	}
+	b.calls.update(EventMsg{ID: "c2", EventType: EventCallIncoming})
+	if _, ok := b.calls.calls["c1"]; ok {
+		t.Error("expired call not pruned")
+	}
+}
+
+func TestParseListCalls(t *testing.T) {
+	tests := []struct {
+		data string
+		ids  []string
+		ok   bool
+	}{
+		{"\n(no active calls)\n", nil, true},
+		{"\n--- No active calls ---\n", nil, true},
+		{
+			"\n--- Active calls (2) ---\n" +
+				"  [line 1, id 3a9f@10.0.0.1]  00:01:02   ESTABLISHED  sip:bob@example.com\n" +
+				"> [line 2, id f00]  00:00:05       RINGING  sip:carol@example.com\n",
+			[]string{"3a9f@10.0.0.1", "f00"}, true,
+		},
+		{"\n--- Active calls (2) ---\n  [line 1, id a]\n", nil, false},
+		{"something else", nil, false},
+	}
+	for _, tt := range tests {
+		ids, ok := parseListCalls(tt.data)
+		if ok != tt.ok || len(ids) != len(tt.ids) {
+			t.Errorf("%q: got %v, %v", tt.data, ids, ok)
+			continue
+		}
+		for _, id := range tt.ids {
+			if !ids[id] {
+				t.Errorf("%q: missing %s", tt.data, id)
+			}
+		}
+	}
+}
+
+func TestCloseMissingCalls(t *testing.T) {
+	b := newBaresip()
+	b.calls.update(EventMsg{ID: "gone", EventType: EventCallEstablished})
+	b.calls.update(EventMsg{ID: "alive", EventType: EventCallEstablished})
+	time.Sleep(time.Millisecond)
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	b.calls.update(EventMsg{ID: "new", EventType: EventCallIncoming})
+
+	b.calls.closeMissing(map[string]bool{"alive": true}, before)
+
+	c, _ := b.Call("gone")
+	if c.State != CallStateClosed || c.CloseReason != lostCallReason {
+		t.Errorf("gone: %+v", c)
+	}
+	for _, id := range []string{"alive", "new"} {
+		if c, _ := b.Call(id); c.State == CallStateClosed {
+			t.Errorf("%s was closed", id)
+		}
+	}
+}
+
+func TestZeroCall(t *testing.T) {
+	b := newBaresip()
+	c, ok := b.Call("missing")
+	if ok {
+		t.Fatal("got a call for an unknown id")
+	}
+
+	ctx := context.Background()
+	for name, f := range map[string]func() error{
+		"Answer":      func() error { return c.Answer(ctx) },
+		"AnswerDir":   func() error { return c.AnswerDir(ctx, "audio=sendrecv") },
+		"Hangup":      func() error { return c.Hangup(ctx) },
+		"Hold":        func() error { return c.Hold(ctx) },
+		"Resume":      func() error { return c.Resume(ctx) },
+		"Mute":        func() error { return c.Mute(ctx) },
+		"Reinvite":    func() error { return c.Reinvite(ctx) },
+		"Transfer":    func() error { return c.Transfer(ctx, "sip:bob@example.com") },
+		"SendDTMF":    func() error { return c.SendDTMF(ctx, "123") },
+		"SetMediaDir": func() error { return c.SetMediaDir(ctx, "audio=sendonly") },
+	} {
+		if err := f(); err != ErrNoCall {
+			t.Errorf("%s: got %v, want %v", name, err, ErrNoCall)
+		}
+	}
+}
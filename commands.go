@@ -2,6 +2,7 @@ package gobaresip
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -69,6 +70,16 @@ type CommandMsg struct {
 	Token   string `json:"token,omitempty"`
 }
 
+// CommandError is returned by Do if baresip reports a command as failed.
+type CommandError struct {
+	Command string
+	Data    string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %s failed: %s", e.Command, e.Data)
+}
+
 func buildCommand(command, params, token string) *CommandMsg {
 	return &CommandMsg{
 		Command: command,
@@ -79,22 +90,82 @@ func buildCommand(command, params, token string) *CommandMsg {
 
 // Cmd will send a raw baresip command over ctrl_tcp.
 func (b *Baresip) Cmd(command, params, token string) error {
+	if b.shuttingDown() {
+		return ErrShutdown
+	}
+	return b.cmd(command, params, token)
+}
+
+// cmd is Cmd without the shutdown check, for use by Shutdown itself.
+func (b *Baresip) cmd(command, params, token string) error {
+	if b.nativeCmds {
+		return b.execCmd(command, params, token)
+	}
+	return b.ctrlCmd(command, params, token, false)
+}
+
+// ctrlCmd sends command over ctrl_tcp. Only the verification of the peer
+// may bypass the hold until the peer is verified.
+func (b *Baresip) ctrlCmd(command, params, token string, verify bool) error {
 	msg, err := json.Marshal(buildCommand(command, params, token))
 	if err != nil {
 		return err
 	}
 
-	if atomic.LoadUint32(&b.ctrlConnAlive) == 0 {
-		return fmt.Errorf("can't write command to closed tcp_ctrl connection")
+	return b.writeCtrl(token, []byte(fmt.Sprintf("%d:%s,", len(msg), msg)), verify)
+}
+
+// Do will send a raw baresip command over ctrl_tcp and wait for its response.
+// Each call gets a unique token, so concurrent callers always receive their own
+// response. Responses to Do are not sent to the ResponseMsg channel.
+func (b *Baresip) Do(ctx context.Context, command, params string) (ResponseMsg, error) {
+	if b.shuttingDown() {
+		return ResponseMsg{}, ErrShutdown
 	}
+	return b.do(ctx, command, params)
+}
 
-	b.ctrlConn.SetWriteDeadline(time.Now().Add(2 * time.Second))
-	_, err = b.ctrlConn.Write([]byte(fmt.Sprintf("%d:%s,", len(msg), msg)))
-	if err != nil {
-		return err
+// do is Do without the shutdown check, for use by Shutdown itself.
+func (b *Baresip) do(ctx context.Context, command, params string) (ResponseMsg, error) {
+	return b.await(ctx, command, params, b.cmd)
+}
+
+// await sends command with send and waits for its response.
+func (b *Baresip) await(ctx context.Context, command, params string, send func(command, params, token string) error) (ResponseMsg, error) {
+	token, ch := b.requests.add(command)
+	defer b.requests.remove(token)
+
+	if err := send(command, params, token); err != nil {
+		return ResponseMsg{}, err
 	}
 
-	return nil
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return ResponseMsg{}, ErrCtrlConnLost
+		}
+		if !r.Ok {
+			return r, &CommandError{Command: command, Data: strings.TrimSpace(r.Data)}
+		}
+		return r, nil
+	case <-ctx.Done():
+		b.unqueueCmd(token)
+		return ResponseMsg{}, ctx.Err()
+	}
+}
+
+// CallCmd will run command on the call with callID. baresip executes most
+// call commands on the current line, so CallCmd selects the call with callfind
+// first. Concurrent CallCmd invocations are serialized and can't select each
+// other's call. Raw commands which change the current line bypass this.
+func (b *Baresip) CallCmd(ctx context.Context, callID, command, params string) (ResponseMsg, error) {
+	b.lineMux.Lock()
+	defer b.lineMux.Unlock()
+
+	if _, err := b.Do(ctx, "callfind", callID); err != nil {
+		return ResponseMsg{}, err
+	}
+	return b.Do(ctx, command, params)
 }
 
 // CmdAccept will accept incoming call
@@ -196,7 +267,7 @@ func (b *Baresip) CmdHangup() error {
 // CmdHangupID will hangup call with Call-ID
 func (b *Baresip) CmdHangupID(callID string) error {
 	c := "hangup"
-	return b.Cmd(c, callID, "cmd_"+c)
+	return b.Cmd(c, callID, "cmd_"+c+"_"+callID)
 }
 
 // CmdHangupall will hangup all calls with direction
@@ -253,7 +324,8 @@ func (b *Baresip) CmdUafind(s string) error {
 	return b.Cmd(c, s, "cmd_"+c+"_"+s)
 }
 
-// CmdUanew will create User-Agent
+// CmdUanew will create User-Agent. AddAccount renders the account line from
+// an Account and should be preferred.
 func (b *Baresip) CmdUanew(s string) error {
 	c := "uanew"
 	return b.Cmd(c, s, "cmd_"+c+"_"+s)
@@ -272,6 +344,12 @@ func (b *Baresip) CmdQuit() error {
 }
 
 func (b *Baresip) CmdWs(raw []byte) error {
+	return b.cmdWs(raw, "")
+}
+
+// cmdWs is CmdWs with tag appended to the tokens, so the websocket hub can
+// route the responses back to the issuing client.
+func (b *Baresip) cmdWs(raw []byte, tag string) error {
 	m := strings.SplitN(string(bytes.TrimSpace(bytes.Join(bytes.Fields(raw), []byte(" ")))), " ", 2)
 	if len(m) < 1 {
 		return nil
@@ -283,17 +361,20 @@ func (b *Baresip) CmdWs(raw []byte) error {
 	}
 
 	if len(m) == 2 && m[0] == "autodialadd" {
-		b.CmdAutodialadd(m[1])
+		b.autodialAdd(m[1])
+		b.Cmd("autodialinfo", "", "cmd_autodialadd"+tag)
 	} else if len(m) == 2 && m[0] == "autodialdel" {
-		b.CmdAutodialdel(m[1])
+		b.autodialDel(m[1])
+		b.Cmd("autodialinfo", "", "cmd_autodialdel"+tag)
 	} else if len(m) == 2 && m[0] == "autohangupgap" {
-		b.CmdAutohangupgap(m[1])
+		b.autohangupGap(m[1])
+		b.Cmd("autodialinfo", "", "cmd_autohangupgap"+tag)
 	} else if m[0] == "autocmdinfo" {
-		b.CmdAutocmdinfo()
+		b.Cmd("autodialinfo", "", "cmd_autocmdinfo"+tag)
 	} else if len(m) == 1 {
-		b.Cmd(m[0], "", "cmd_"+m[0])
+		b.Cmd(m[0], "", "cmd_"+m[0]+tag)
 	} else if len(m) == 2 {
-		b.Cmd(m[0], m[1], "cmd_"+m[0])
+		b.Cmd(m[0], m[1], "cmd_"+m[0]+tag)
 	}
 	return nil
 }
@@ -308,6 +389,11 @@ func cutSpace(str string) string {
 }
 
 func (b *Baresip) CmdAutodialadd(s string) error {
+	b.autodialAdd(s)
+	return b.Cmd("autodialinfo", "", "cmd_autodialadd")
+}
+
+func (b *Baresip) autodialAdd(s string) {
 	in := strings.Split(cutSpace(s), ",")
 	for _, v := range in {
 		gap := 60
@@ -328,8 +414,6 @@ func (b *Baresip) CmdAutodialadd(s string) error {
 			go b.autoDialSchedule(parts[0], gap)
 		}
 	}
-
-	return b.Cmd("autodialinfo", "", "cmd_autodialadd")
 }
 
 func (b *Baresip) autoDialSchedule(num string, gap int) {
@@ -351,6 +435,11 @@ func (b *Baresip) autoDialSchedule(num string, gap int) {
 }
 
 func (b *Baresip) CmdAutodialdel(s string) error {
+	b.autodialDel(s)
+	return b.Cmd("autodialinfo", "", "cmd_autodialdel")
+}
+
+func (b *Baresip) autodialDel(s string) {
 	data := strings.Split(cutSpace(s), ",")
 	for _, d := range data {
 		parts := strings.Split(d, ";autodialgap=")
@@ -358,19 +447,20 @@ func (b *Baresip) CmdAutodialdel(s string) error {
 		delete(b.autoCmd.num, parts[0])
 		b.autoCmd.mux.Unlock()
 	}
-
-	return b.Cmd("autodialinfo", "", "cmd_autodialdel")
 }
 
 func (b *Baresip) CmdAutohangupgap(s string) error {
+	b.autohangupGap(s)
+	return b.Cmd("autodialinfo", "", "cmd_autohangupgap")
+}
+
+func (b *Baresip) autohangupGap(s string) {
 	if n, err := strconv.Atoi(s); err == nil {
 		if n < 0 {
 			n = 0
 		}
 		atomic.StoreUint32(&b.autoCmd.hangupGap, uint32(n))
 	}
-
-	return b.Cmd("autodialinfo", "", "cmd_autohangupgap")
 }
 
 func (b *Baresip) CmdAutocmdinfo() error {
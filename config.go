@@ -0,0 +1,262 @@
+package gobaresip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Config is a baresip config file. It is rendered in memory and applied with
+// SetConfig, so no config files are needed on disk.
+type Config struct {
+	// Core
+	PollMethod string
+
+	// SIP
+	SIPListen       string
+	SIPTransports   []string
+	SIPTransDef     string
+	SIPCertificate  string
+	SIPCAFile       string
+	SIPCAPath       string
+	SIPVerifyServer string
+
+	// Call
+	CallLocalTimeout int
+	CallMaxCalls     int
+
+	// Audio
+	AudioPath   string
+	AudioPlayer string
+	AudioSource string
+	AudioAlert  string
+
+	// AVT - Audio/Video Transport
+	RTPTOS            int
+	RTPPorts          string
+	RTPBandwidth      string
+	RTCPMux           bool
+	JitterBufferType  string
+	JitterBufferDelay string
+	RTPStats          bool
+	RTPTimeout        int
+
+	// Network
+	NetInterface string
+	DNSServers   []string
+
+	// Modules
+	ModulePath string
+	Modules    []string
+	ModuleApps []string
+
+	// Module parameters
+	CtrlTCPListen string
+
+	// Extra holds all other lines in their original order.
+	Extra []ConfigEntry
+
+	// Accounts are created once baresip is initialized. RenderAccounts
+	// renders them as accounts file.
+	Accounts []Account
+
+	// present holds the keys found by ParseConfig, so their zero numbers and
+	// flags are rendered too instead of falling back to baresip's defaults.
+	present map[string]bool
+}
+
+// ConfigEntry is a single key value line of a baresip config.
+type ConfigEntry struct {
+	Key   string
+	Value string
+}
+
+// configField binds a config key to one field of Config. Exactly one of the
+// pointers is set. list is rendered comma separated, lines as repeated keys.
+type configField struct {
+	key   string
+	str   *string
+	num   *int
+	flag  *bool
+	list  *[]string
+	lines *[]string
+}
+
+func (c *Config) fields() []configField {
+	return []configField{
+		{key: "poll_method", str: &c.PollMethod},
+
+		{key: "sip_listen", str: &c.SIPListen},
+		{key: "sip_transports", list: &c.SIPTransports},
+		{key: "sip_trans_def", str: &c.SIPTransDef},
+		{key: "sip_certificate", str: &c.SIPCertificate},
+		{key: "sip_cafile", str: &c.SIPCAFile},
+		{key: "sip_capath", str: &c.SIPCAPath},
+		{key: "sip_verify_server", str: &c.SIPVerifyServer},
+
+		{key: "call_local_timeout", num: &c.CallLocalTimeout},
+		{key: "call_max_calls", num: &c.CallMaxCalls},
+
+		{key: "audio_path", str: &c.AudioPath},
+		{key: "audio_player", str: &c.AudioPlayer},
+		{key: "audio_source", str: &c.AudioSource},
+		{key: "audio_alert", str: &c.AudioAlert},
+
+		{key: "rtp_tos", num: &c.RTPTOS},
+		{key: "rtp_ports", str: &c.RTPPorts},
+		{key: "rtp_bandwidth", str: &c.RTPBandwidth},
+		{key: "rtcp_mux", flag: &c.RTCPMux},
+		{key: "jitter_buffer_type", str: &c.JitterBufferType},
+		{key: "jitter_buffer_delay", str: &c.JitterBufferDelay},
+		{key: "rtp_stats", flag: &c.RTPStats},
+		{key: "rtp_timeout", num: &c.RTPTimeout},
+
+		{key: "net_interface", str: &c.NetInterface},
+		{key: "dns_server", lines: &c.DNSServers},
+
+		{key: "module_path", str: &c.ModulePath},
+		{key: "module", lines: &c.Modules},
+		{key: "module_app", lines: &c.ModuleApps},
+
+		{key: "ctrl_tcp_listen", str: &c.CtrlTCPListen},
+	}
+}
+
+// clone returns a copy of c which shares no slices with c.
+func (c *Config) clone() *Config {
+	cc := *c
+	for _, f := range cc.fields() {
+		switch {
+		case f.list != nil:
+			*f.list = append([]string(nil), *f.list...)
+		case f.lines != nil:
+			*f.lines = append([]string(nil), *f.lines...)
+		}
+	}
+	cc.Extra = append([]ConfigEntry(nil), c.Extra...)
+	cc.Accounts = append([]Account(nil), c.Accounts...)
+	if c.present != nil {
+		cc.present = make(map[string]bool, len(c.present))
+		for k := range c.present {
+			cc.present[k] = true
+		}
+	}
+	return &cc
+}
+
+// Render returns c in the syntax of baresip's config file. Zero numbers and
+// flags are left out unless ParseConfig found them, so baresip's defaults
+// apply to them.
+func (c *Config) Render() []byte {
+	var buf bytes.Buffer
+	write := func(key, value string) {
+		fmt.Fprintf(&buf, "%s\t\t%s\n", key, value)
+	}
+
+	for _, f := range c.fields() {
+		switch {
+		case f.str != nil && *f.str != "":
+			write(f.key, *f.str)
+		case f.num != nil && (*f.num != 0 || c.present[f.key]):
+			write(f.key, strconv.Itoa(*f.num))
+		case f.flag != nil && *f.flag:
+			write(f.key, "yes")
+		case f.flag != nil && c.present[f.key]:
+			write(f.key, "no")
+		case f.list != nil && len(*f.list) > 0:
+			write(f.key, strings.Join(*f.list, ","))
+		case f.lines != nil:
+			for _, v := range *f.lines {
+				write(f.key, v)
+			}
+		}
+	}
+	for _, e := range c.Extra {
+		write(e.Key, e.Value)
+	}
+	return buf.Bytes()
+}
+
+// RenderAccounts returns the Accounts in the syntax of baresip's accounts file.
+func (c *Config) RenderAccounts() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, a := range c.Accounts {
+		line, err := a.Line()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseConfig parses a baresip config file. Unknown keys are kept in Extra,
+// so Render gives back an equivalent config.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	fields := make(map[string]configField)
+	for _, f := range c.fields() {
+		fields[f.key] = f
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		key, value := parseConfigLine(sc.Text())
+		if key == "" {
+			continue
+		}
+
+		f, ok := fields[key]
+		if ok {
+			if c.present == nil {
+				c.present = make(map[string]bool)
+			}
+			c.present[key] = true
+		}
+		switch {
+		case !ok:
+			c.Extra = append(c.Extra, ConfigEntry{Key: key, Value: value})
+		case f.str != nil:
+			*f.str = value
+		case f.num != nil:
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: %s: %v", n, key, err)
+			}
+			*f.num = v
+		case f.flag != nil:
+			*f.flag = value == "yes"
+		case f.list != nil:
+			*f.list = strings.Split(value, ",")
+		case f.lines != nil:
+			*f.lines = append(*f.lines, value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseConfigLine splits a line into key and value and drops comments.
+func parseConfigLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", ""
+	}
+
+	parts := strings.SplitN(strings.Join(strings.Fields(line), " "), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	value := parts[1]
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	} else if value[0] == '#' {
+		value = ""
+	}
+	return parts[0], value
+}
@@ -0,0 +1,158 @@
+package gobaresip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigLine(t *testing.T) {
+	tests := []struct {
+		line, key, value string
+	}{
+		{"", "", ""},
+		{"   ", "", ""},
+		{"# comment", "", ""},
+		{"sip_listen\t\t0.0.0.0:5060", "sip_listen", "0.0.0.0:5060"},
+		{"  audio_player   alsa,default  ", "audio_player", "alsa,default"},
+		{"module_path /usr/lib/baresip # the modules", "module_path", "/usr/lib/baresip"},
+		{"sip_cafile #unset", "sip_cafile", ""},
+		{"rtcp_mux", "rtcp_mux", ""},
+		{"http_listen 0.0.0.0:8000#no comment", "http_listen", "0.0.0.0:8000#no comment"},
+	}
+	for _, tt := range tests {
+		key, value := parseConfigLine(tt.line)
+		if key != tt.key || value != tt.value {
+			t.Errorf("parseConfigLine(%q) = %q, %q, want %q, %q", tt.line, key, value, tt.key, tt.value)
+		}
+	}
+}
+
+const testConfig = `# Core
+poll_method		epoll
+
+sip_listen		0.0.0.0:5060
+sip_transports		udp,tcp
+call_local_timeout	0
+call_max_calls		4
+rtp_tos			0
+rtcp_mux		yes
+rtp_stats		no
+dns_server		1.1.1.1:53
+dns_server		8.8.8.8:53
+
+module_path		/usr/local/lib/baresip/modules
+module			g711.so
+module			account.so # accounts file
+module_app		menu.so
+ctrl_tcp_listen		0.0.0.0:4444
+opus_bitrate		28000
+http_listen		0.0.0.0:8000
+`
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		PollMethod:    "epoll",
+		SIPListen:     "0.0.0.0:5060",
+		SIPTransports: []string{"udp", "tcp"},
+		CallMaxCalls:  4,
+		RTCPMux:       true,
+		DNSServers:    []string{"1.1.1.1:53", "8.8.8.8:53"},
+		ModulePath:    "/usr/local/lib/baresip/modules",
+		Modules:       []string{"g711.so", "account.so"},
+		ModuleApps:    []string{"menu.so"},
+		CtrlTCPListen: "0.0.0.0:4444",
+		Extra: []ConfigEntry{
+			{Key: "opus_bitrate", Value: "28000"},
+			{Key: "http_listen", Value: "0.0.0.0:8000"},
+		},
+		present: map[string]bool{
+			"poll_method": true, "sip_listen": true, "sip_transports": true,
+			"call_local_timeout": true, "call_max_calls": true, "rtp_tos": true,
+			"rtcp_mux": true, "rtp_stats": true, "dns_server": true,
+			"module_path": true, "module": true, "module_app": true,
+			"ctrl_tcp_listen": true,
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got  %+v\nwant %+v", c, want)
+	}
+
+	if _, err := ParseConfig([]byte("call_max_calls\t\tmany\n")); err == nil {
+		t.Error("invalid number accepted")
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	c := &Config{
+		SIPListen:     "0.0.0.0:5060",
+		SIPTransports: []string{"udp", "tls"},
+		RTPTOS:        184,
+		RTCPMux:       true,
+		Modules:       []string{"g711.so", "ctrl_tcp.so"},
+		CtrlTCPListen: "127.0.0.1:4444",
+		Extra:         []ConfigEntry{{Key: "opus_bitrate", Value: "28000"}},
+	}
+	want := "sip_listen\t\t0.0.0.0:5060\n" +
+		"sip_transports\t\tudp,tls\n" +
+		"rtp_tos\t\t184\n" +
+		"rtcp_mux\t\tyes\n" +
+		"module\t\tg711.so\n" +
+		"module\t\tctrl_tcp.so\n" +
+		"ctrl_tcp_listen\t\t127.0.0.1:4444\n" +
+		"opus_bitrate\t\t28000\n"
+	if got := string(c.Render()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderParsedZeroValues(t *testing.T) {
+	c, err := ParseConfig([]byte("call_local_timeout 0\nrtp_tos 0\nrtp_stats no\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "call_local_timeout\t\t0\n" +
+		"rtp_tos\t\t0\n" +
+		"rtp_stats\t\tno\n"
+	if got := string(c.Render()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ParseConfig(c.Render())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, c2) {
+		t.Errorf("round trip changed the config:\n got %+v\nwant %+v", c2, c)
+	}
+}
+
+func TestRenderAccounts(t *testing.T) {
+	c := &Config{Accounts: []Account{
+		{AOR: "alice@example.com", AuthPass: "secret"},
+		{AOR: "sip:bob@example.com", RegInt: -1},
+	}}
+	got, err := c.RenderAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<sip:alice@example.com>;auth_pass=secret;mwi=no\n" +
+		"<sip:bob@example.com>;regint=0;mwi=no\n"
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	c.Accounts = append(c.Accounts, Account{})
+	if _, err := c.RenderAccounts(); err == nil {
+		t.Error("account without aor accepted")
+	}
+}
@@ -0,0 +1,216 @@
+package gobaresip
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	// Delay before the first attempt to reconnect to ctrl_tcp.
+	reconnectMin = 100 * time.Millisecond
+
+	// Upper bound of the exponential reconnect backoff.
+	reconnectMax = 5 * time.Second
+)
+
+var (
+	// ErrCtrlNotConnected is returned for commands which can't be written or
+	// queued because the ctrl_tcp connection is down.
+	ErrCtrlNotConnected = errors.New("can't write command to closed tcp_ctrl connection")
+
+	// ErrCtrlConnLost is returned by Do if the ctrl_tcp connection dropped
+	// after the command was sent, so it is unknown whether it was executed.
+	ErrCtrlConnLost = errors.New("tcp_ctrl connection lost before response")
+)
+
+// queuedCmd is a command which waits for the ctrl_tcp connection to come back.
+type queuedCmd struct {
+	token string
+	msg   []byte
+}
+
+// CtrlConnected reports whether the ctrl_tcp connection is currently up.
+func (b *Baresip) CtrlConnected() bool {
+	return atomic.LoadUint32(&b.ctrlConnAlive) == 1
+}
+
+// writeCtrl writes the netstring msg to ctrl_tcp or queues it while the
+// connection is down. While the peer is not verified yet all commands but
+// the verification are held back in the queue, regardless of its size.
+func (b *Baresip) writeCtrl(token string, msg []byte, verify bool) error {
+	b.ctrlMux.Lock()
+	defer b.ctrlMux.Unlock()
+
+	alive := atomic.LoadUint32(&b.ctrlConnAlive) == 1
+	switch {
+	case verify && !alive:
+		return ErrCtrlNotConnected
+	case verify:
+	case alive && b.ctrlHeld:
+		if b.stopped() {
+			return ErrCtrlNotConnected
+		}
+		b.cmdQueue = append(b.cmdQueue, queuedCmd{token: token, msg: msg})
+		return nil
+	case !alive:
+		if b.stopped() || len(b.cmdQueue) >= b.cmdQueueSize {
+			return ErrCtrlNotConnected
+		}
+		b.cmdQueue = append(b.cmdQueue, queuedCmd{token: token, msg: msg})
+		return nil
+	}
+
+	return b.writeConn(token, msg)
+}
+
+// releaseCtrl sends the held commands once the peer is verified.
+func (b *Baresip) releaseCtrl() {
+	b.ctrlMux.Lock()
+	defer b.ctrlMux.Unlock()
+
+	b.ctrlHeld = false
+	if atomic.LoadUint32(&b.ctrlConnAlive) == 1 {
+		b.flushCmdQueue()
+	}
+}
+
+// writeConn must be called with ctrlMux held and the connection alive.
+func (b *Baresip) writeConn(token string, msg []byte) error {
+	b.ctrlConn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if _, err := b.ctrlConn.Write(msg); err != nil {
+		// Let the reader notice the broken connection and reconnect.
+		atomic.StoreUint32(&b.ctrlConnAlive, 0)
+		b.ctrlConn.Close()
+		return err
+	}
+	b.requests.sent(token)
+	return nil
+}
+
+// unqueueCmd drops the queued command with token, so a command whose caller
+// gave up is not executed after the connection is back.
+func (b *Baresip) unqueueCmd(token string) {
+	b.ctrlMux.Lock()
+	defer b.ctrlMux.Unlock()
+
+	for i, c := range b.cmdQueue {
+		if c.token == token {
+			b.cmdQueue = append(b.cmdQueue[:i:i], b.cmdQueue[i+1:]...)
+			return
+		}
+	}
+}
+
+// flushCmdQueue must be called with ctrlMux held right after connecting.
+// Commands which can't be written stay queued for the next connection.
+func (b *Baresip) flushCmdQueue() {
+	for len(b.cmdQueue) > 0 {
+		c := b.cmdQueue[0]
+		if err := b.writeConn(c.token, c.msg); err != nil {
+			b.log(LevelError, err.Error())
+			return
+		}
+		b.cmdQueue = b.cmdQueue[1:]
+	}
+	b.cmdQueue = nil
+}
+
+// ctrlDown marks the connection as dead. Commands which were already sent
+// fail, because it is unknown whether baresip executed them, and are never
+// replayed. Queued commands are kept.
+func (b *Baresip) ctrlDown(err error) {
+	b.log(LevelWarn, "ctrl_tcp connection lost", LogAttr{Key: "error", Value: err})
+
+	b.ctrlMux.Lock()
+	atomic.StoreUint32(&b.ctrlConnAlive, 0)
+	b.ctrlConn.Close()
+	b.ctrlMux.Unlock()
+
+	b.requests.fail(false)
+	b.sendCtrlEvent(EventCtrlDisconnected, err.Error())
+}
+
+// reconnectCtrl dials ctrl_tcp with exponential backoff. It returns false
+// if the Baresip instance was stopped in the meantime.
+func (b *Baresip) reconnectCtrl() bool {
+	wait := reconnectMin
+	for {
+		select {
+		case <-b.quit:
+			return false
+		case <-time.After(wait):
+		}
+
+		if err := b.connectCtrl(); err != nil {
+			b.log(LevelWarn, err.Error())
+			if wait *= 2; wait > reconnectMax {
+				wait = reconnectMax
+			}
+			continue
+		}
+
+		go func() {
+			if b.verifyPeer() && !b.verifyReconnect() {
+				return
+			}
+			// Events which came in while ctrl_tcp was down are lost.
+			if !b.nativeEvents {
+				b.resyncCalls()
+			}
+		}()
+		b.sendCtrlEvent(EventCtrlConnected, b.ctrlAddr)
+		return true
+	}
+}
+
+// sendCtrlEvent reports a change of the ctrl_tcp connection state as EventMsg
+// of class ctrl.
+func (b *Baresip) sendCtrlEvent(t EventType, param string) {
+	e := EventMsg{
+		Event:     true,
+		Type:      t.String(),
+		Class:     "ctrl",
+		Param:     param,
+		EventType: t,
+	}
+
+	rj, err := json.Marshal(e)
+	if err != nil {
+		b.log(LevelError, err.Error(), LogAttr{Key: "type", Value: e.Type})
+		return
+	}
+	e.RawJSON = rj
+
+	b.sendEvent(e)
+}
+
+// stopCtrl closes the ctrl_tcp connection for good and fails all outstanding
+// requests.
+func (b *Baresip) stopCtrl() {
+	b.quitOnce.Do(func() {
+		close(b.quit)
+		b.stopBlocking()
+
+		b.ctrlMux.Lock()
+		atomic.StoreUint32(&b.ctrlConnAlive, 0)
+		if b.ctrlConn != nil {
+			b.ctrlConn.Close()
+		}
+		b.cmdQueue = nil
+		b.ctrlMux.Unlock()
+
+		b.requests.fail(true)
+	})
+}
+
+func (b *Baresip) stopped() bool {
+	select {
+	case <-b.quit:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package gobaresip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDoUnqueuesOnTimeout(t *testing.T) {
+	b := newBaresip()
+	b.cmdQueueSize = 10
+	b.cmdQueue = []queuedCmd{{token: "other"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.Do(ctx, "dial", "sip:bob@example.com"); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(b.cmdQueue) != 1 || b.cmdQueue[0].token != "other" {
+		t.Errorf("queue %+v, want only the other command", b.cmdQueue)
+	}
+}
+
+func TestCtrlHeldUntilVerified(t *testing.T) {
+	b := newBaresip()
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	b.ctrlConn = conn
+	b.ctrlConnAlive = 1
+	b.ctrlHeld = true
+
+	got := make(chan string, 3)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := peer.Read(buf)
+			if err != nil {
+				return
+			}
+			got <- string(buf[:n])
+		}
+	}()
+
+	if err := b.writeCtrl("user", []byte("user"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeCtrl("nonce", []byte("nonce"), true); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-got; msg != "nonce" {
+		t.Fatalf("got %q before verification, want nonce", msg)
+	}
+
+	b.releaseCtrl()
+	if msg := <-got; msg != "user" {
+		t.Fatalf("got %q after verification, want user", msg)
+	}
+	if len(b.cmdQueue) != 0 {
+		t.Errorf("queue not flushed: %+v", b.cmdQueue)
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	tests := []struct {
+		nativeEvents, nativeCmds bool
+		want                     bool
+	}{
+		{false, false, true},
+		{false, true, true},
+		{true, false, true},
+		{true, true, false},
+	}
+	for _, tt := range tests {
+		b := newBaresip()
+		b.ctrlNonce = "n"
+		b.nativeEvents, b.nativeCmds = tt.nativeEvents, tt.nativeCmds
+		if got := b.verifyPeer(); got != tt.want {
+			t.Errorf("events %v, commands %v: got %v, want %v", tt.nativeEvents, tt.nativeCmds, got, tt.want)
+		}
+	}
+}
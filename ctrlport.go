@@ -0,0 +1,110 @@
+package gobaresip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nonceCmd is registered in baresip and prints the nonce of this instance.
+const nonceCmd = "gobaresip_nonce"
+
+// prepareAutoPort picks a free local port for ctrl_tcp and puts it into the
+// config. Without SetConfig the config file in the config path is used. The
+// Config of SetConfig is copied and left untouched.
+func (b *Baresip) prepareAutoPort() error {
+	if b.config == nil {
+		data, err := os.ReadFile(filepath.Join(b.configPath, "config"))
+		if err != nil {
+			return fmt.Errorf("%v: automatic ctrl_tcp port needs a config", err)
+		}
+		if b.config, err = ParseConfig(data); err != nil {
+			return err
+		}
+	} else {
+		b.config = b.config.clone()
+	}
+
+	// The config template loads it as module_app.
+	if !hasModule(b.config.Modules, "ctrl_tcp.so") && !hasModule(b.config.ModuleApps, "ctrl_tcp.so") {
+		b.config.Modules = append(b.config.Modules, "ctrl_tcp.so")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	b.ctrlAddr = l.Addr().String()
+	l.Close()
+	b.config.CtrlTCPListen = b.ctrlAddr
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	b.ctrlNonce = hex.EncodeToString(nonce)
+	return nil
+}
+
+// hasModule reports whether mods contains the module name. matchString can't
+// be used, an empty list matches everything there.
+func hasModule(mods []string, name string) bool {
+	for _, m := range mods {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCtrl checks that the peer of the ctrl_tcp connection is our own
+// baresip instance and not another process which took over the port. The
+// nonce is always asked over ctrl_tcp, also with SetInProcessCommands. Once
+// verified, the held commands are sent.
+func (b *Baresip) verifyCtrl(ctx context.Context) error {
+	r, err := b.await(ctx, nonceCmd, "", func(command, params, token string) error {
+		return b.ctrlCmd(command, params, token, true)
+	})
+	if err != nil {
+		return fmt.Errorf("ctrl_tcp peer %s verification failed: %w", b.ctrlAddr, err)
+	}
+	if strings.TrimSpace(r.Data) != b.ctrlNonce {
+		return fmt.Errorf("ctrl_tcp peer %s is not this instance", b.ctrlAddr)
+	}
+	b.releaseCtrl()
+	return nil
+}
+
+// verifyRetry reports whether verifyCtrl failed only because the peer didn't
+// answer yet, e.g. as the main loop is still starting.
+func verifyRetry(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrCtrlNotConnected) || errors.Is(err, ErrCtrlConnLost)
+}
+
+// verifyReconnect verifies the peer after a reconnect and stops the
+// ctrl_tcp connection for good if it is not this instance.
+func (b *Baresip) verifyReconnect() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := b.verifyCtrl(ctx); err != nil {
+		b.log(LevelError, err.Error())
+		b.stopCtrl()
+		return false
+	}
+	return true
+}
+
+// verifyPeer reports whether the ctrl_tcp peer has to be verified. This is
+// needed whenever ctrl_tcp carries commands or events.
+func (b *Baresip) verifyPeer() bool {
+	return b.ctrlNonce != "" && b.useCtrl()
+}
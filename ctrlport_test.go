@@ -0,0 +1,46 @@
+package gobaresip
+
+import "testing"
+
+func TestPrepareAutoPortCopiesConfig(t *testing.T) {
+	modules := make([]string, 1, 4)
+	modules[0] = "account.so"
+	cfg := &Config{Modules: modules, CtrlTCPListen: "0.0.0.0:4444"}
+
+	b := newBaresip()
+	b.config = cfg
+	if err := b.prepareAutoPort(); err != nil {
+		t.Fatal(err)
+	}
+	if b.config == cfg {
+		t.Fatal("config was not copied")
+	}
+	if cfg.CtrlTCPListen != "0.0.0.0:4444" || len(cfg.Modules) != 1 || modules[:2][1] != "" {
+		t.Errorf("caller's config was changed: %+v", cfg)
+	}
+	if b.config.CtrlTCPListen != b.ctrlAddr || !matchString(b.config.Modules, "ctrl_tcp.so") {
+		t.Errorf("copy lacks ctrl_tcp: %+v", b.config)
+	}
+}
+
+func TestPrepareAutoPortModule(t *testing.T) {
+	tests := []struct {
+		cfg          Config
+		modules, app int
+	}{
+		{Config{}, 1, 0},
+		{Config{Modules: []string{"g711.so"}}, 2, 0},
+		{Config{Modules: []string{"ctrl_tcp.so"}}, 1, 0},
+		{Config{ModuleApps: []string{"menu.so", "ctrl_tcp.so"}}, 0, 2},
+	}
+	for _, tt := range tests {
+		b := newBaresip()
+		b.config = &tt.cfg
+		if err := b.prepareAutoPort(); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.config.Modules) != tt.modules || len(b.config.ModuleApps) != tt.app {
+			t.Errorf("%+v: got modules %v, apps %v", tt.cfg, b.config.Modules, b.config.ModuleApps)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package gobaresip
+
+import "sync/atomic"
+
+// QueueStats holds the delivery counters of a queue.
+type QueueStats struct {
+	// Delivered counts the messages put into the queue.
+	Delivered uint64
+	// Dropped counts the messages lost because the queue was full.
+	Dropped uint64
+	// HighWater is the highest number of messages queued at once.
+	HighWater uint64
+}
+
+// DeliveryStats holds the counters of all queues Baresip delivers to.
+type DeliveryStats struct {
+	Events        QueueStats
+	Responses     QueueStats
+	WsEvents      QueueStats
+	WsResponses   QueueStats
+	Subscriptions QueueStats
+}
+
+type queueStats struct {
+	delivered uint64
+	dropped   uint64
+	highWater uint64
+}
+
+// sent counts a delivered message, n is the queue length after it.
+func (s *queueStats) sent(n int) {
+	atomic.AddUint64(&s.delivered, 1)
+	for {
+		hw := atomic.LoadUint64(&s.highWater)
+		if uint64(n) <= hw || atomic.CompareAndSwapUint64(&s.highWater, hw, uint64(n)) {
+			return
+		}
+	}
+}
+
+func (s *queueStats) drop() {
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+func (s *queueStats) stats() QueueStats {
+	return QueueStats{
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+		HighWater: atomic.LoadUint64(&s.highWater),
+	}
+}
+
+type deliveryStats struct {
+	events        queueStats
+	responses     queueStats
+	wsEvents      queueStats
+	wsResponses   queueStats
+	subscriptions queueStats
+}
+
+// DeliveryStats returns the delivery counters of all queues.
+func (b *Baresip) DeliveryStats() DeliveryStats {
+	return DeliveryStats{
+		Events:        b.stats.events.stats(),
+		Responses:     b.stats.responses.stats(),
+		WsEvents:      b.stats.wsEvents.stats(),
+		WsResponses:   b.stats.wsResponses.stats(),
+		Subscriptions: b.stats.subscriptions.stats(),
+	}
+}
+
+// outQueue is a typed channel together with the message to put into it.
+type outQueue interface {
+	// trySend sends without blocking and reports whether it did.
+	trySend() bool
+	// send blocks until the message is sent or quit or done is closed and
+	// reports whether it was sent.
+	send(quit, done <-chan struct{}) bool
+	// dropOldest removes the oldest queued message and reports whether there
+	// was one.
+	dropOldest() bool
+	len() int
+}
+
+type eventQueue struct {
+	ch chan EventMsg
+	e  EventMsg
+}
+
+func (q eventQueue) trySend() bool {
+	select {
+	case q.ch <- q.e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q eventQueue) send(quit, done <-chan struct{}) bool {
+	select {
+	case q.ch <- q.e:
+		return true
+	case <-quit:
+	case <-done:
+	}
+	return false
+}
+
+func (q eventQueue) dropOldest() bool {
+	select {
+	case <-q.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q eventQueue) len() int {
+	return len(q.ch)
+}
+
+type responseQueue struct {
+	ch chan ResponseMsg
+	r  ResponseMsg
+}
+
+func (q responseQueue) trySend() bool {
+	select {
+	case q.ch <- q.r:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q responseQueue) send(quit, done <-chan struct{}) bool {
+	select {
+	case q.ch <- q.r:
+		return true
+	case <-quit:
+	case <-done:
+	}
+	return false
+}
+
+func (q responseQueue) dropOldest() bool {
+	select {
+	case <-q.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q responseQueue) len() int {
+	return len(q.ch)
+}
+
+// deliver puts the message of q into its channel according to p. A blocked
+// send gives up when quit or done is closed.
+func deliver(q outQueue, p OverflowPolicy, st *queueStats, quit, done <-chan struct{}) {
+	switch p {
+	case OverflowBlock:
+		if !q.send(quit, done) {
+			st.drop()
+			return
+		}
+	case OverflowDropNewest:
+		if !q.trySend() {
+			st.drop()
+			return
+		}
+	default:
+		for !q.trySend() {
+			if q.dropOldest() {
+				st.drop()
+			}
+		}
+	}
+	st.sent(q.len())
+}
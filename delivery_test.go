@@ -0,0 +1,45 @@
+package gobaresip
+
+import "testing"
+
+func TestDeliverPolicies(t *testing.T) {
+	tests := []struct {
+		policy    OverflowPolicy
+		want      []string
+		delivered uint64
+	}{
+		{OverflowDropOldest, []string{"b", "c"}, 3},
+		{OverflowDropNewest, []string{"a", "b"}, 2},
+	}
+	for _, tt := range tests {
+		ch := make(chan EventMsg, 2)
+		var st queueStats
+		for _, id := range []string{"a", "b", "c"} {
+			deliver(eventQueue{ch, EventMsg{ID: id}}, tt.policy, &st, nil, nil)
+		}
+		close(ch)
+		var got []string
+		for e := range ch {
+			got = append(got, e.ID)
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("policy %d: got %v, want %v", tt.policy, got, tt.want)
+		}
+		s := st.stats()
+		if s.Dropped != 1 || s.Delivered != tt.delivered || s.HighWater != 2 {
+			t.Errorf("policy %d: stats %+v", tt.policy, s)
+		}
+	}
+}
+
+func TestDeliverBlockGivesUp(t *testing.T) {
+	ch := make(chan ResponseMsg)
+	quit := make(chan struct{})
+	close(quit)
+
+	var st queueStats
+	deliver(responseQueue{ch, ResponseMsg{}}, OverflowBlock, &st, quit, nil)
+	if s := st.stats(); s.Dropped != 1 || s.Delivered != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
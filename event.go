@@ -0,0 +1,144 @@
+package gobaresip
+
+import "fmt"
+
+// EventType mirrors baresip's enum ua_event from baresip.h.
+type EventType int
+
+// The values equal the UA_EVENT_* constants of baresip.
+const (
+	EventRegistering EventType = iota
+	EventRegisterOK
+	EventRegisterFail
+	EventUnregistering
+	EventFallbackOK
+	EventFallbackFail
+	EventMWINotify
+	EventShutdown
+	EventExit
+
+	EventCallIncoming
+	EventCallOutgoing
+	EventCallRinging
+	EventCallProgress
+	EventCallAnswered
+	EventCallEstablished
+	EventCallClosed
+	EventCallTransfer
+	EventCallBlindTransfer
+	EventCallTransferFailed
+	EventCallDTMFStart
+	EventCallDTMFEnd
+	EventCallRTPEstab
+	EventCallRTCP
+	EventCallMENC
+	EventVUTX
+	EventVURX
+	EventAudioError
+	EventCallLocalSDP
+	EventCallRemoteSDP
+	EventModule
+	EventCustom
+)
+
+// Events which are generated by go-baresip itself.
+const (
+	// EventAudioEOF is an AUDIO_ERROR caused by the end of an audio file.
+	EventAudioEOF EventType = iota + 100
+	// EventCtrlConnected is sent when the ctrl_tcp connection came back.
+	EventCtrlConnected
+	// EventCtrlDisconnected is sent when the ctrl_tcp connection dropped.
+	EventCtrlDisconnected
+)
+
+// EventUnknown is used for event types go-baresip doesn't know about.
+const EventUnknown EventType = -1
+
+// eventNames holds the type strings baresip puts into its JSON events.
+var eventNames = map[EventType]string{
+	EventRegistering:        "REGISTERING",
+	EventRegisterOK:         "REGISTER_OK",
+	EventRegisterFail:       "REGISTER_FAIL",
+	EventUnregistering:      "UNREGISTERING",
+	EventFallbackOK:         "FALLBACK_OK",
+	EventFallbackFail:       "FALLBACK_FAIL",
+	EventMWINotify:          "MWI_NOTIFY",
+	EventShutdown:           "SHUTDOWN",
+	EventExit:               "EXIT",
+	EventCallIncoming:       "CALL_INCOMING",
+	EventCallOutgoing:       "CALL_OUTGOING",
+	EventCallRinging:        "CALL_RINGING",
+	EventCallProgress:       "CALL_PROGRESS",
+	EventCallAnswered:       "CALL_ANSWERED",
+	EventCallEstablished:    "CALL_ESTABLISHED",
+	EventCallClosed:         "CALL_CLOSED",
+	EventCallTransfer:       "TRANSFER",
+	EventCallBlindTransfer:  "BLIND_TRANSFER",
+	EventCallTransferFailed: "TRANSFER_FAILED",
+	EventCallDTMFStart:      "CALL_DTMF_START",
+	EventCallDTMFEnd:        "CALL_DTMF_END",
+	EventCallRTPEstab:       "CALL_RTPESTAB",
+	EventCallRTCP:           "CALL_RTCP",
+	EventCallMENC:           "CALL_MENC",
+	EventVUTX:               "VU_TX_REPORT",
+	EventVURX:               "VU_RX_REPORT",
+	EventAudioError:         "AUDIO_ERROR",
+	EventCallLocalSDP:       "CALL_LOCAL_SDP",
+	EventCallRemoteSDP:      "CALL_REMOTE_SDP",
+	EventModule:             "MODULE",
+	EventCustom:             "CUSTOM",
+
+	EventAudioEOF:         "AUDIO_EOF",
+	EventCtrlConnected:    "CTRL_CONNECTED",
+	EventCtrlDisconnected: "CTRL_DISCONNECTED",
+}
+
+var eventTypes = func() map[string]EventType {
+	m := make(map[string]EventType, len(eventNames))
+	for t, n := range eventNames {
+		m[n] = t
+	}
+	return m
+}()
+
+// String returns the type string baresip uses for t.
+func (t EventType) String() string {
+	if n, ok := eventNames[t]; ok {
+		return n
+	}
+	return "UNKNOWN"
+}
+
+// ParseEventType returns the EventType for the type string of an event.
+func ParseEventType(s string) (EventType, error) {
+	if t, ok := eventTypes[s]; ok {
+		return t, nil
+	}
+	return EventUnknown, fmt.Errorf("unknown event type %q", s)
+}
+
+// IsRegisterEvent reports whether t belongs to the registration of a UA.
+func (t EventType) IsRegisterEvent() bool {
+	return t >= EventRegistering && t <= EventFallbackFail
+}
+
+// IsCallEvent reports whether t belongs to the lifecycle or media of a call.
+func (t EventType) IsCallEvent() bool {
+	return t >= EventCallIncoming && t <= EventCallMENC ||
+		t == EventCallLocalSDP || t == EventCallRemoteSDP
+}
+
+// IsVUEvent reports whether t is a VU meter report.
+func (t EventType) IsVUEvent() bool {
+	return t == EventVUTX || t == EventVURX
+}
+
+// IsAudioEvent reports whether t is an audio error or end of file.
+func (t EventType) IsAudioEvent() bool {
+	return t == EventAudioError || t == EventAudioEOF
+}
+
+// IsCtrlEvent reports whether t reports the state of the ctrl_tcp connection.
+func (t EventType) IsCtrlEvent() bool {
+	return t == EventCtrlConnected || t == EventCtrlDisconnected
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -40,8 +41,14 @@ func main() {
 	}()
 
 	go func() {
-		// Give baresip some time to init and register ua
-		time.Sleep(1 * time.Second)
+		// Wait until the ua is registered
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := gb.WaitRegistered(ctx); err != nil {
+			log.Println(err)
+			return
+		}
 
 		if err := gb.CmdDial("012345"); err != nil {
 			log.Println(err)
@@ -0,0 +1,40 @@
+package gobaresip
+
+import "github.com/goccy/go-json"
+
+// execCmd runs a command through baresip's command subsystem on the re main
+// thread. The result is handled like a response from ctrl_tcp.
+func (b *Baresip) execCmd(command, params, token string) error {
+	line := command
+	if params != "" {
+		line += " " + params
+	}
+
+	err := b.runOnMain(func() {
+		data, err := runCmd(line)
+		b.execDone(token, err, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.requests.sent(token)
+	return nil
+}
+
+// execDone turns the output of a command into a ResponseMsg like ctrl_tcp.
+func (b *Baresip) execDone(token string, err int, data []byte) {
+	rj, jerr := json.Marshal(ResponseMsg{
+		Response: true,
+		Ok:       err == 0,
+		Data:     string(data),
+		Token:    token,
+	})
+	if jerr != nil {
+		b.log(LevelError, jerr.Error(), LogAttr{Key: "data", Value: string(data)})
+		return
+	}
+
+	// Handled off the re main thread, so slow consumers don't stall baresip.
+	b.execq.push(func() { b.handleResponse(rj) })
+}
@@ -0,0 +1,18 @@
+package gobaresip
+
+import "testing"
+
+func TestExecDoneNeverBlocks(t *testing.T) {
+	b := newBaresip()
+	// Nobody handles the results, execDone must still return.
+	for i := 0; i < 1000; i++ {
+		b.execDone("cmd_reginfo", 0, []byte("ok"))
+	}
+
+	token, ch := b.requests.add("reginfo")
+	b.execDone(token, 0, []byte("done"))
+	b.execq.runAll()
+	if r := <-ch; r.Data != "done" || !r.Ok {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,55 @@
+package gobaresip
+
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// current is the Baresip instance which receives the callbacks from C.
+// baresip itself is a process wide singleton, so there is only one.
+var current struct {
+	sync.RWMutex
+	b *Baresip
+}
+
+func setCurrent(b *Baresip) {
+	current.Lock()
+	current.b = b
+	current.Unlock()
+}
+
+func getCurrent() *Baresip {
+	current.RLock()
+	defer current.RUnlock()
+	return current.b
+}
+
+// goEvent receives the JSON encoded events of the in-process event handler.
+// It runs on the re main thread and only queues the event.
+//
+//export goEvent
+func goEvent(msg *C.char, n C.int) {
+	if b := getCurrent(); b != nil {
+		data := C.GoBytes(unsafe.Pointer(msg), n)
+		b.nativeq.push(func() { b.handleEvent(data) })
+	}
+}
+
+// goRunOnMain runs the func queued with id. It runs on the re main thread.
+//
+//export goRunOnMain
+func goRunOnMain(id C.int) {
+	if b := getCurrent(); b != nil {
+		b.mainq.run(int(id))
+	}
+}
+
+// goLog receives the log lines of baresip.
+//
+//export goLog
+func goLog(level C.uint, msg *C.char) {
+	if b := getCurrent(); b != nil {
+		b.logBaresip(LogLevel(level), C.GoString(msg))
+	}
+}
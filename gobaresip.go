@@ -64,15 +64,164 @@ static void set_ua_exit_handler()
 int mainLoop(){
 	return re_main(signal_handler);
 }
+
+extern void goLog(unsigned int level, char *msg);
+
+static void log_handler(uint32_t level, const char *msg)
+{
+	goLog((unsigned int)level, (char *)msg);
+}
+
+static struct log lg = {LE_INIT, log_handler};
+
+static void register_log_handler()
+{
+	log_register_handler(&lg);
+}
+
+static void unregister_log_handler()
+{
+	log_unregister_handler(&lg);
+}
+
+extern void goEvent(char *msg, int n);
+
+static void event_handler(struct ua *ua, enum ua_event ev,
+			  struct call *call, const char *prm, void *arg)
+{
+	struct odict *od = NULL;
+	char *buf = NULL;
+	int err;
+	(void)arg;
+
+	err = odict_alloc(&od, 8);
+	if (err)
+		return;
+
+	// Encode the event exactly like ctrl_tcp does.
+	err  = odict_entry_add(od, "event", ODICT_BOOL, true);
+	err |= event_encode_dict(od, ua, ev, call, prm);
+	if (err)
+		goto out;
+
+	err = re_sdprintf(&buf, "%H", json_encode_odict, od);
+	if (err)
+		goto out;
+
+	goEvent(buf, (int)str_len(buf));
+
+ out:
+	mem_deref(buf);
+	mem_deref(od);
+}
+
+static int register_event_handler()
+{
+	return uag_event_register(event_handler, NULL);
+}
+
+static void unregister_event_handler()
+{
+	uag_event_unregister(event_handler);
+}
+
+extern void goRunOnMain(int id);
+
+static struct mqueue *main_mq;
+
+static void main_mqueue_handler(int id, void *data, void *arg)
+{
+	(void)data;
+	(void)arg;
+
+	goRunOnMain(id);
+}
+
+static int main_queue_init()
+{
+	return mqueue_alloc(&main_mq, main_mqueue_handler, NULL);
+}
+
+static void main_queue_close()
+{
+	main_mq = mem_deref(main_mq);
+}
+
+// main_queue_push wakes up the re main thread to run the Go func with id.
+static int main_queue_push(int id)
+{
+	if (!main_mq)
+		return EINVAL;
+
+	return mqueue_push(main_mq, id, NULL);
+}
+
+static char ctrl_nonce[33];
+
+static int cmd_nonce(struct re_printf *pf, void *arg)
+{
+	(void)arg;
+
+	return re_hprintf(pf, "%s", ctrl_nonce);
+}
+
+static const struct cmd nonce_cmdv[] = {
+	{"gobaresip_nonce", 0, 0, "go-baresip instance nonce", cmd_nonce},
+};
+
+static int nonce_register(const char *nonce)
+{
+	str_ncpy(ctrl_nonce, nonce, sizeof(ctrl_nonce));
+
+	return cmd_register(baresip_commands(), nonce_cmdv,
+			    ARRAY_SIZE(nonce_cmdv));
+}
+
+static void nonce_unregister()
+{
+	cmd_unregister(baresip_commands(), nonce_cmdv);
+}
+
+// ua_add creates a User-Agent and registers it like the account module does.
+static int ua_add(const char *line)
+{
+	struct ua *ua = NULL;
+	int err;
+
+	err = ua_alloc(&ua, line);
+	if (err)
+		return err;
+
+	if (!account_regint(ua_account(ua)))
+		return 0;
+
+	if (account_prio(ua_account(ua)))
+		return ua_fallback(ua);
+
+	return ua_register(ua);
+}
+
+static int cmd_print_handler(const char *p, size_t size, void *arg)
+{
+	return mbuf_write_mem(arg, (const uint8_t *)p, size);
+}
+
+// cmd_run runs a long command and prints its output into mb.
+static int cmd_run(const char *line, struct mbuf *mb)
+{
+	struct re_printf pf = {cmd_print_handler, mb};
+
+	return cmd_process_long(baresip_commands(), line, str_len(line), &pf, NULL);
+}
 */
 import "C"
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -104,23 +253,74 @@ type EventMsg struct {
 	RemoteAudioDir  string `json:"remoteaudiodir,omitempty"`
 	Param           string `json:"param,omitempty"`
 	RawJSON         []byte `json:"-"`
+
+	// EventType is the parsed Type.
+	EventType EventType `json:"-"`
 }
 
 type Baresip struct {
 	userAgent      string
 	ctrlAddr       string
 	wsAddr         string
+	pprofAddr      string
+	wsAuth         []WsAuthFunc
+	wsBasic        bool
+	wsOrigins      []string
+	wsTLS          *tls.Config
+	wsCert         *certReloader
+	historySize    int
+	historyAge     time.Duration
 	configPath     string
 	audioPath      string
 	debug          bool
+	waitRegister   bool
+	nativeEvents   bool
+	nativeCmds     bool
+	config         *Config
+	ctrlAutoPort   bool
+	ctrlNonce      string
+	logger         LogHandler
+	customLogger   bool
+	ctrlMux        sync.Mutex
 	ctrlConn       net.Conn
 	ctrlConnAlive  uint32
+	ctrlHeld       bool
+	cmdQueue       []queuedCmd
+	cmdQueueSize   int
+	quit           chan struct{}
+	quitOnce       sync.Once
+	unblock        chan struct{}
+	legacyEvents   uint32
+	unblockOnce    sync.Once
+	ready          chan struct{}
+	done           chan struct{}
 	responseChan   chan ResponseMsg
 	eventChan      chan EventMsg
-	responseWsChan chan []byte
-	eventWsChan    chan []byte
+	responseWsChan chan ResponseMsg
+	eventWsChan    chan EventMsg
+	hub            *wsHub
+	web            http.Handler
+	servers        []*http.Server
 	ctrlStream     *reader
 	autoCmd        ac
+	requests       rq
+	calls          *CallRegistry
+	subs           subscribers
+	accounts       accountList
+	regs           registrations
+	eventPolicy    OverflowPolicy
+	responsePolicy OverflowPolicy
+	stats          deliveryStats
+	lineMux        sync.Mutex
+	outMux         sync.RWMutex
+	outClosed      bool
+	readDone       chan struct{}
+	execq          taskQueue
+	nativeq        taskQueue
+	hookq          taskQueue
+	mainq          mainQueue
+	running        uint32 // 1 once Run started, 2 if it was stopped before
+	shutdown       uint32
 }
 
 type ac struct {
@@ -130,15 +330,124 @@ type ac struct {
 	hangupGap uint32
 }
 
-func New(options ...func(*Baresip) error) (*Baresip, error) {
+// rq keeps track of the commands issued by Do which still wait for a response.
+// doTag marks the tokens of Do, cmd_<command>#do<n>, so late responses can
+// be recognized after Do gave up.
+const doTag = "#do"
+
+// isDoToken reports whether token was made by rq.add.
+func isDoToken(token string) bool {
+	i := strings.LastIndex(token, doTag)
+	if i < 0 {
+		return false
+	}
+	_, err := strconv.ParseUint(token[i+len(doTag):], 10, 64)
+	return err == nil
+}
+
+type rq struct {
+	mux sync.Mutex
+	seq uint64
+	req map[string]*request
+}
+
+type request struct {
+	ch   chan ResponseMsg
+	sent bool
+}
+
+// add registers a new unique token for command and returns it together
+// with the channel its response will be delivered to.
+func (q *rq) add(command string) (string, chan ResponseMsg) {
+	ch := make(chan ResponseMsg, 1)
+	q.mux.Lock()
+	q.seq++
+	token := "cmd_" + command + doTag + strconv.FormatUint(q.seq, 10)
+	q.req[token] = &request{ch: ch}
+	q.mux.Unlock()
+	return token, ch
+}
+
+func (q *rq) remove(token string) {
+	q.mux.Lock()
+	delete(q.req, token)
+	q.mux.Unlock()
+}
+
+// sent marks the request with token as written to ctrl_tcp.
+func (q *rq) sent(token string) {
+	q.mux.Lock()
+	if r, ok := q.req[token]; ok {
+		r.sent = true
+	}
+	q.mux.Unlock()
+}
+
+// done hands r to its waiting request and reports whether there was one.
+func (q *rq) done(r ResponseMsg) bool {
+	q.mux.Lock()
+	req, ok := q.req[r.Token]
+	if ok {
+		delete(q.req, r.Token)
+	}
+	q.mux.Unlock()
+	if ok {
+		req.ch <- r
+	}
+	return ok
+}
+
+// fail closes the channels of all requests which were already sent, or of
+// all requests if unsent is true. Their responses can't arrive anymore.
+func (q *rq) fail(unsent bool) {
+	q.mux.Lock()
+	for token, req := range q.req {
+		if req.sent || unsent {
+			delete(q.req, token)
+			close(req.ch)
+		}
+	}
+	q.mux.Unlock()
+}
+
+// newBaresip returns a Baresip with all internal state initialized but
+// without options applied and without baresip set up.
+func newBaresip() *Baresip {
 	b := &Baresip{
 		responseChan: make(chan ResponseMsg, 100),
 		eventChan:    make(chan EventMsg, 100),
+		quit:         make(chan struct{}),
+		unblock:      make(chan struct{}),
+		logger:       stdLogHandler{},
+		ready:        make(chan struct{}),
+		done:         make(chan struct{}),
+		readDone:     make(chan struct{}),
+		execq:        newTaskQueue(),
+		mainq:        mainQueue{fns: make(map[int]func())},
+		nativeq:      newTaskQueue(),
+		hookq:        newTaskQueue(),
+
+		historySize: defaultHistorySize,
+		historyAge:  defaultHistoryAge,
 	}
+	b.calls = newCallRegistry(b)
+	b.subs.subs = make(map[*subscription]struct{})
+	b.accounts.m = make(map[string]Account)
+	b.regs.regs = make(map[string]*Registration)
+	b.regs.changed = make(chan struct{})
+	b.autoCmd.num = make(map[string]int)
+	b.requests.req = make(map[string]*request)
+	return b
+}
 
+func New(options ...func(*Baresip) error) (*Baresip, error) {
+	b := newBaresip()
 	if err := b.SetOption(options...); err != nil {
 		return nil, err
 	}
+	if b.wsAddr == "" && (b.wsCert != nil || b.wsTLS != nil) {
+		return nil, fmt.Errorf("ws tls without ws address: mount WebHandler on your own TLS server instead")
+	}
 
 	if b.audioPath == "" {
 		b.audioPath = "."
@@ -146,135 +455,196 @@ func New(options ...func(*Baresip) error) (*Baresip, error) {
 	if b.configPath == "" {
 		b.configPath = "."
 	}
+	if b.ctrlAddr == "" && b.config != nil && b.config.CtrlTCPListen != "" {
+		b.ctrlAddr = strings.Replace(b.config.CtrlTCPListen, "0.0.0.0", "127.0.0.1", 1)
+	}
 	if b.ctrlAddr == "" {
 		b.ctrlAddr = "127.0.0.1:4444"
 	}
 	if b.userAgent == "" {
 		b.userAgent = "go-baresip"
 	}
+	if b.ctrlAutoPort {
+		if err := b.prepareAutoPort(); err != nil {
+			return nil, err
+		}
+	}
 
-	b.autoCmd.num = make(map[string]int)
-
-	if b.wsAddr != "" {
-		b.responseWsChan = make(chan []byte, 100)
-		b.eventWsChan = make(chan []byte, 100)
-
-		h := newWsHub(b)
-		go h.run()
+	b.responseWsChan = make(chan ResponseMsg, 100)
+	b.eventWsChan = make(chan EventMsg, 100)
+	b.hub = newWsHub(b)
+	b.web = b.newWebHandler()
 
-		http.HandleFunc("/", serveRoot)
-		http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			serveWs(h, w, r)
-		})
-		go http.ListenAndServe(b.wsAddr, nil)
+	if err := b.listen(); err != nil {
+		return nil, err
 	}
+	go b.hub.run()
 
 	if err := b.setup(); err != nil {
+		b.stopCtrl()
+		b.closeServers()
 		return nil, err
 	}
 
 	// Simple solution for this https://github.com/baresip/baresip/issues/584
-	go b.keepActive()
+	if b.useCtrl() {
+		go b.keepActive()
+	}
 
 	return b, nil
 }
 
 func (b *Baresip) connectCtrl() error {
-	var err error
-	b.ctrlConn, err = net.Dial("tcp", b.ctrlAddr)
+	conn, err := net.Dial("tcp", b.ctrlAddr)
 	if err != nil {
 		atomic.StoreUint32(&b.ctrlConnAlive, 0)
 		return fmt.Errorf("%v: please make sure ctrl_tcp is enabled", err)
 	}
 
+	b.ctrlMux.Lock()
+	defer b.ctrlMux.Unlock()
+
+	if b.stopped() {
+		conn.Close()
+		return ErrCtrlNotConnected
+	}
+
+	b.ctrlConn = conn
 	b.ctrlStream = newReader(b.ctrlConn)
 
 	atomic.StoreUint32(&b.ctrlConnAlive, 1)
+	// Commands wait until the peer is known to be this instance.
+	b.ctrlHeld = b.verifyPeer()
+	if !b.ctrlHeld {
+		b.flushCmdQueue()
+	}
 	return nil
 }
 
+// read supervises the ctrl_tcp connection. It reads until the connection
+// fails and reconnects with backoff until the Baresip instance is stopped.
 func (b *Baresip) read() {
+	defer close(b.readDone)
+
 	for {
-		if atomic.LoadUint32(&b.ctrlConnAlive) == 0 {
-			break
+		err := b.readCtrl()
+		if b.stopped() {
+			return
 		}
+		b.ctrlDown(err)
+		if !b.reconnectCtrl() {
+			return
+		}
+	}
+}
 
+func (b *Baresip) readCtrl() error {
+	for {
 		msg, err := b.ctrlStream.readNetstring()
 		if err != nil {
-			log.Println(err)
-			break
+			return err
 		}
 
 		if bytes.Contains(msg, []byte("\"event\":true")) {
-			if bytes.Contains(msg, []byte(",end of file")) {
-				msg = bytes.Replace(msg, []byte("AUDIO_ERROR"), []byte("AUDIO_EOF"), 1)
+			// In-process events arrive through the event handler already.
+			if !b.nativeEvents {
+				b.handleEvent(msg)
 			}
+		} else if bytes.Contains(msg, []byte("\"response\":true")) {
+			b.handleResponse(msg)
+		}
+	}
+}
 
-			var e EventMsg
-			e.RawJSON = msg
+func (b *Baresip) handleEvent(msg []byte) {
+	if bytes.Contains(msg, []byte(",end of file")) {
+		msg = bytes.Replace(msg, []byte("AUDIO_ERROR"), []byte("AUDIO_EOF"), 1)
+	}
 
-			err := json.Unmarshal(e.RawJSON, &e)
-			if err != nil {
-				log.Println(err, string(e.RawJSON))
-				continue
-			}
+	var e EventMsg
+	e.RawJSON = msg
 
-			b.eventChan <- e
-			if b.wsAddr != "" {
-				select {
-				case b.eventWsChan <- e.RawJSON:
-				default:
-				}
-			}
-		} else if bytes.Contains(msg, []byte("\"response\":true")) {
+	err := json.Unmarshal(e.RawJSON, &e)
+	if err != nil {
+		b.log(LevelError, err.Error(), LogAttr{Key: "json", Value: string(e.RawJSON)})
+		return
+	}
+	e.EventType, _ = ParseEventType(e.Type)
 
-			var r ResponseMsg
-			r.RawJSON = msg
+	b.calls.update(e)
+	b.updateRegistration(e)
+	b.sendEvent(e)
+}
 
-			err := json.Unmarshal(r.RawJSON, &r)
-			if err != nil {
-				log.Println(err, string(r.RawJSON))
-				continue
-			}
+func (b *Baresip) sendEvent(e EventMsg) {
+	b.outMux.RLock()
+	defer b.outMux.RUnlock()
+	if b.outClosed {
+		return
+	}
 
-			if strings.HasPrefix(r.Token, "cmd_dial") {
-				if d := atomic.LoadUint32(&b.autoCmd.hangupGap); d > 0 {
-					if id := findID([]byte(r.Data)); len(id) > 1 {
-						go func() {
-							time.Sleep(time.Duration(d) * time.Second)
-							b.CmdHangupID(id)
-						}()
-					}
-				}
-			}
+	b.subs.send(e, &b.stats.subscriptions, b.unblock)
+	if atomic.LoadUint32(&b.legacyEvents) == 1 {
+		deliver(eventQueue{b.eventChan, e}, b.eventPolicy, &b.stats.events, b.unblock, nil)
+	}
+	deliver(eventQueue{b.eventWsChan, e}, OverflowDropNewest, &b.stats.wsEvents, nil, nil)
+}
 
-			if strings.HasPrefix(r.Token, "cmd_auto") {
-				r.Ok = true
-				b.autoCmd.mux.RLock()
-				r.Data = fmt.Sprintf("dial%v;hangupgap=%d",
-					b.autoCmd.num,
-					atomic.LoadUint32(&b.autoCmd.hangupGap),
-				)
-				b.autoCmd.mux.RUnlock()
-				r.Data = strings.Replace(r.Data, " ", ",", -1)
-				r.Data = strings.Replace(r.Data, ":", ";autodialgap=", -1)
-				rj, err := json.Marshal(r)
-				if err != nil {
-					log.Println(err, r.Data)
-					continue
-				}
-				r.RawJSON = rj
-			}
+func (b *Baresip) handleResponse(msg []byte) {
+	var r ResponseMsg
+	r.RawJSON = msg
+
+	err := json.Unmarshal(r.RawJSON, &r)
+	if err != nil {
+		b.log(LevelError, err.Error(), LogAttr{Key: "json", Value: string(r.RawJSON)})
+		return
+	}
 
-			b.responseChan <- r
-			if b.wsAddr != "" {
-				select {
-				case b.responseWsChan <- r.RawJSON:
-				default:
-				}
+	if strings.HasPrefix(r.Token, "cmd_dial") {
+		if d := atomic.LoadUint32(&b.autoCmd.hangupGap); d > 0 {
+			if id := findID([]byte(r.Data)); len(id) > 1 {
+				go func() {
+					time.Sleep(time.Duration(d) * time.Second)
+					b.CmdHangupID(id)
+				}()
 			}
 		}
 	}
+
+	if strings.HasPrefix(r.Token, "cmd_auto") {
+		r.Ok = true
+		b.autoCmd.mux.RLock()
+		r.Data = fmt.Sprintf("dial%v;hangupgap=%d",
+			b.autoCmd.num,
+			atomic.LoadUint32(&b.autoCmd.hangupGap),
+		)
+		b.autoCmd.mux.RUnlock()
+		r.Data = strings.Replace(r.Data, " ", ",", -1)
+		r.Data = strings.Replace(r.Data, ":", ";autodialgap=", -1)
+		rj, err := json.Marshal(r)
+		if err != nil {
+			b.log(LevelError, err.Error(), LogAttr{Key: "data", Value: r.Data})
+			return
+		}
+		r.RawJSON = rj
+	}
+
+	// Responses to Do belong to its caller alone, they are neither sent to
+	// the ResponseMsg channel nor to websocket clients. Late ones, after Do
+	// gave up, are dropped.
+	if b.requests.done(r) {
+		return
+	}
+	if isDoToken(r.Token) {
+		b.log(LevelDebug, "dropping late response", LogAttr{Key: "token", Value: r.Token})
+		return
+	}
+	b.outMux.RLock()
+	if !b.outClosed {
+		deliver(responseQueue{b.responseChan, r}, b.responsePolicy, &b.stats.responses, b.unblock, nil)
+	}
+	b.outMux.RUnlock()
+	deliver(responseQueue{b.responseWsChan, r}, OverflowDropNewest, &b.stats.wsResponses, nil, nil)
 }
 
 func findID(data []byte) string {
@@ -287,17 +657,50 @@ func findID(data []byte) string {
 	return ""
 }
 
+// Close stops the ctrl_tcp connection and closes all channels. Use Shutdown
+// to stop a running instance gracefully.
 func (b *Baresip) Close() {
-	atomic.StoreUint32(&b.ctrlConnAlive, 0)
-	if b.ctrlConn != nil {
-		b.ctrlConn.Close()
+	b.stopCtrl()
+	if b.claimRun() {
+		b.end(0)
+	}
+	b.closeServers()
+	b.closeOut()
+}
+
+// stopBlocking makes blocked and future sends of OverflowBlock policies give
+// up, so a slow consumer can't hold up shutting down.
+func (b *Baresip) stopBlocking() {
+	b.unblockOnce.Do(func() {
+		close(b.unblock)
+	})
+}
+
+// closeOut closes the EventMsg and ResponseMsg channels and all subscriptions.
+// Senders hold outMux for reading and check outClosed, so nothing is sent on a
+// closed channel. Blocked senders give up because unblock is closed and the
+// subscriptions are canceled before the write lock is taken.
+func (b *Baresip) closeOut() {
+	b.stopBlocking()
+	b.subs.closeAll()
+
+	b.outMux.Lock()
+	defer b.outMux.Unlock()
+	if b.outClosed {
+		return
 	}
+	b.outClosed = true
 	close(b.responseChan)
 	close(b.eventChan)
+	b.subs.closeAll()
 }
 
 // GetEventChan returns the receive-only EventMsg channel for reading data.
+// All callers share this channel, use Subscribe for independent consumers.
+// Events are only delivered to it after the first call, so applications
+// which only use Subscribe don't fill it up.
 func (b *Baresip) GetEventChan() <-chan EventMsg {
+	atomic.StoreUint32(&b.legacyEvents, 1)
 	return b.eventChan
 }
 
@@ -309,13 +712,20 @@ func (b *Baresip) GetResponseChan() <-chan ResponseMsg {
 var ping = []byte(`16:{"token":"ping"},`)
 
 func (b *Baresip) keepActive() {
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
+
 	for {
-		time.Sleep(1 * time.Second)
-		if atomic.LoadUint32(&b.ctrlConnAlive) == 0 {
-			break
+		select {
+		case <-b.quit:
+			return
+		case <-tick.C:
+		}
+		b.ctrlMux.Lock()
+		if atomic.LoadUint32(&b.ctrlConnAlive) == 1 {
+			b.writeConn("", ping)
 		}
-		b.ctrlConn.SetWriteDeadline(time.Now().Add(2 * time.Second))
-		b.ctrlConn.Write(ping)
+		b.ctrlMux.Unlock()
 	}
 }
 
@@ -329,11 +739,15 @@ func (b *Baresip) setup() error {
 
 	err := C.libre_init()
 	if err != 0 {
-		log.Printf("libre init failed with error code %d\n", err)
+		b.log(LevelError, fmt.Sprintf("libre init failed with error code %d", err))
 		return b.end(err)
 	}
 
-	if b.debug {
+	setCurrent(b)
+	if b.customLogger {
+		C.log_enable_stdout(0)
+		C.register_log_handler()
+	} else if b.debug {
 		C.log_enable_stdout(1)
 	} else {
 		C.log_enable_stdout(0)
@@ -345,16 +759,21 @@ func (b *Baresip) setup() error {
 		C.conf_path_set(cp)
 	}
 
-	err = C.conf_configure()
+	if b.config != nil {
+		cfg := append(b.config.Render(), '\n')
+		err = C.conf_configure_buf((*C.uint8_t)(unsafe.Pointer(&cfg[0])), C.size_t(len(cfg)))
+	} else {
+		err = C.conf_configure()
+	}
 	if err != 0 {
-		log.Printf("baresip configure failed with error code %d\n", err)
+		b.log(LevelError, fmt.Sprintf("baresip configure failed with error code %d", err))
 		return b.end(err)
 	}
 
 	// Top-level baresip struct init must be done AFTER configuration is complete.
 	err = C.baresip_init(C.conf_config())
 	if err != 0 {
-		log.Printf("baresip main init failed with error code %d\n", err)
+		b.log(LevelError, fmt.Sprintf("baresip main init failed with error code %d", err))
 		return b.end(err)
 	}
 
@@ -366,19 +785,57 @@ func (b *Baresip) setup() error {
 
 	err = C.ua_init(ua, 1, 1, 1)
 	if err != 0 {
-		log.Printf("baresip ua init failed with error code %d\n", err)
+		b.log(LevelError, fmt.Sprintf("baresip ua init failed with error code %d", err))
 		return b.end(err)
 	}
 
 	C.set_net_change_handler()
 	C.set_ua_exit_handler()
 
+	if b.nativeEvents {
+		err = C.register_event_handler()
+		if err != 0 {
+			b.log(LevelError, fmt.Sprintf("baresip event handler registration failed with error code %d", err))
+			return b.end(err)
+		}
+	}
+	if b.ctrlNonce != "" {
+		cn := C.CString(b.ctrlNonce)
+		defer C.free(unsafe.Pointer(cn))
+		err = C.nonce_register(cn)
+		if err != 0 {
+			b.log(LevelError, fmt.Sprintf("nonce command registration failed with error code %d", err))
+			return b.end(err)
+		}
+	}
+
+	b.mainq.mux.Lock()
+	err = C.main_queue_init()
+	b.mainq.mux.Unlock()
+	if err != 0 {
+		b.log(LevelError, fmt.Sprintf("main thread queue init failed with error code %d", err))
+		return b.end(err)
+	}
+	if b.nativeCmds {
+		go b.execq.run(b.quit)
+	}
+	if b.nativeEvents {
+		go b.nativeq.run(b.quit)
+	}
+
 	err = C.conf_modules()
 	if err != 0 {
-		log.Printf("baresip load modules failed with error code %d\n", err)
+		b.log(LevelError, fmt.Sprintf("baresip load modules failed with error code %d", err))
 		return b.end(err)
 	}
 
+	if b.config != nil {
+		if err := b.addConfigAccounts(); err != nil {
+			b.end(1)
+			return err
+		}
+	}
+
 	if b.debug {
 		C.log_enable_debug(1)
 		C.uag_enable_sip_trace(1)
@@ -393,18 +850,87 @@ func (b *Baresip) setup() error {
 		err = C.uag_set_extra_params(ua_eprm)
 	*/
 
-	if err := b.connectCtrl(); err != nil {
-		b.end(1)
-		return err
+	if b.useCtrl() {
+		if err := b.connectCtrl(); err != nil {
+			b.end(1)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addConfigAccounts creates the User-Agents of the Accounts in config. It runs
+// before the main loop, so it calls baresip directly.
+func (b *Baresip) addConfigAccounts() error {
+	for _, a := range b.config.Accounts {
+		line, err := a.Line()
+		if err != nil {
+			return err
+		}
+
+		cl := C.CString(line)
+		ret := C.ua_add(cl)
+		C.free(unsafe.Pointer(cl))
+		if ret != 0 {
+			return fmt.Errorf("can't add account %s: error code %d", a.aor(), ret)
+		}
+
+		b.accounts.mux.Lock()
+		b.accounts.m[a.aor()] = a
+		b.accounts.mux.Unlock()
 	}
+	return nil
+}
 
+// pushMain wakes up the re main thread to run the func queued with id.
+func pushMain(id int) error {
+	if err := C.main_queue_push(C.int(id)); err != 0 {
+		return fmt.Errorf("can't queue for main thread: error code %d", err)
+	}
 	return nil
 }
 
-// Run a baresip instance
+// runCmd runs a long command through baresip's command subsystem and returns
+// its output. It must be called on the re main thread.
+func runCmd(line string) ([]byte, int) {
+	mb := C.mbuf_alloc(256)
+	if mb == nil {
+		return nil, int(C.ENOMEM)
+	}
+	defer C.mem_deref(unsafe.Pointer(mb))
+
+	cl := C.CString(line)
+	defer C.free(unsafe.Pointer(cl))
+
+	err := C.cmd_run(cl, mb)
+	return C.GoBytes(unsafe.Pointer(mb.buf), C.int(mb.end)), int(err)
+}
+
+// useCtrl reports whether the ctrl_tcp connection is needed.
+func (b *Baresip) useCtrl() bool {
+	return !b.nativeEvents || !b.nativeCmds
+}
+
+// Run a baresip instance. It returns ErrStopped after Shutdown or Close.
 func (b *Baresip) Run() error {
-	go b.read()
-	err := b.end(C.mainLoop())
+	defer close(b.done)
+
+	if !atomic.CompareAndSwapUint32(&b.running, 0, 1) {
+		return ErrStopped
+	}
+	go b.hookq.run(b.quit)
+	if b.useCtrl() {
+		go b.read()
+	} else {
+		close(b.readDone)
+	}
+	go b.signalReady()
+	ret := C.mainLoop()
+
+	// Stop the ctrl_tcp supervisor before baresip is torn down.
+	b.stopCtrl()
+	err := b.end(ret)
 	if err.Error() == "0" {
 		return nil
 	}
@@ -416,6 +942,21 @@ func (b *Baresip) end(err C.int) error {
 		C.ua_stop_all(1)
 	}
 
+	if b.nativeEvents {
+		C.unregister_event_handler()
+	}
+	b.mainq.mux.Lock()
+	b.mainq.closed = true
+	C.main_queue_close()
+	b.mainq.mux.Unlock()
+	if b.ctrlNonce != "" {
+		C.nonce_unregister()
+	}
+	if b.customLogger {
+		C.unregister_log_handler()
+	}
+	setCurrent(nil)
+
 	C.ua_close()
 	C.module_app_unload()
 	C.conf_close()
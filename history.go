@@ -0,0 +1,120 @@
+package gobaresip
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistorySize = 100
+	defaultHistoryAge  = 10 * time.Minute
+)
+
+// historyEntry is an event of the websocket hub, RawJSON is stamped with seq.
+// Entries with event set are markers for GET /events streams and carry no
+// sequence number.
+type historyEntry struct {
+	seq   uint64
+	at    time.Time
+	e     EventMsg
+	event string
+}
+
+// eventHistory is a ring buffer of the most recent events. It stamps every
+// event and websocket response with a monotonically increasing sequence
+// number. It is owned by the websocket hub.
+type eventHistory struct {
+	size   int
+	maxAge time.Duration
+
+	buf   []historyEntry
+	start int
+	n     int
+	seq   uint64
+	// lost is the highest sequence number of an event which was evicted.
+	lost uint64
+}
+
+func newEventHistory(size int, maxAge time.Duration) *eventHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &eventHistory{size: size, maxAge: maxAge, buf: make([]historyEntry, size)}
+}
+
+// next returns the next sequence number without keeping anything.
+func (h *eventHistory) next() uint64 {
+	h.seq++
+	return h.seq
+}
+
+// add stamps e with the next sequence number and keeps it.
+func (h *eventHistory) add(e EventMsg) historyEntry {
+	seq := h.next()
+	e.RawJSON = stampSeq(e.RawJSON, seq)
+	he := historyEntry{seq: seq, at: time.Now(), e: e}
+
+	if h.size == 0 {
+		h.lost = seq
+		return he
+	}
+	if h.n < h.size {
+		h.buf[(h.start+h.n)%h.size] = he
+		h.n++
+	} else {
+		h.lost = h.buf[h.start].seq
+		h.buf[h.start] = he
+		h.start = (h.start + 1) % h.size
+	}
+	return he
+}
+
+// since returns the kept events after seq which are not older than maxAge.
+// gap reports that events after seq are not kept anymore.
+func (h *eventHistory) since(seq uint64) (out []historyEntry, gap bool) {
+	gap = seq < h.lost
+	now := time.Now()
+	for i := 0; i < h.n; i++ {
+		he := h.buf[(h.start+i)%h.size]
+		if he.seq <= seq {
+			continue
+		}
+		if h.maxAge > 0 && now.Sub(he.at) > h.maxAge {
+			gap = true
+			continue
+		}
+		out = append(out, he)
+	}
+	return out, gap
+}
+
+// replay returns the events to replay after seq and the WsHistory marker
+// which has to be sent first, if any. A seq newer than the history is from
+// a previous instance and everything is replayed.
+func (h *eventHistory) replay(seq uint64) ([]historyEntry, *WsHistory) {
+	if seq > h.seq {
+		out, _ := h.since(0)
+		return out, &WsHistory{History: HistoryReset, Since: seq}
+	}
+	out, gap := h.since(seq)
+	if gap {
+		return out, &WsHistory{History: HistoryGap, Since: seq}
+	}
+	return out, nil
+}
+
+// stampSeq adds "seq" as first member to the JSON object raw.
+func stampSeq(raw []byte, seq uint64) []byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) < 2 || raw[0] != '{' {
+		return raw
+	}
+	out := make([]byte, 0, len(raw)+24)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, seq, 10)
+	if rest := bytes.TrimSpace(raw[1:]); len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, raw[1:]...)
+}
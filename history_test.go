@@ -0,0 +1,88 @@
+package gobaresip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStampSeq(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{`{"event":true}`, `{"seq":7,"event":true}`},
+		{` {"a":1} `, `{"seq":7,"a":1}`},
+		{`{}`, `{"seq":7}`},
+		{`{ }`, `{"seq":7 }`},
+		{`[1]`, `[1]`},
+		{``, ``},
+	}
+	for _, tt := range tests {
+		if got := string(stampSeq([]byte(tt.raw), 7)); got != tt.want {
+			t.Errorf("stampSeq(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func seqs(hes []historyEntry) []uint64 {
+	var out []uint64
+	for _, he := range hes {
+		out = append(out, he.seq)
+	}
+	return out
+}
+
+func TestHistoryReplay(t *testing.T) {
+	h := newEventHistory(3, time.Minute)
+	for i := 0; i < 5; i++ {
+		h.add(EventMsg{RawJSON: []byte(`{}`)})
+	}
+	// A response takes a sequence number but is not kept.
+	h.next()
+
+	tests := []struct {
+		since  uint64
+		want   []uint64
+		marker string
+	}{
+		{6, nil, ""},
+		{3, []uint64{4, 5}, ""},
+		{2, []uint64{3, 4, 5}, ""},
+		{1, []uint64{3, 4, 5}, HistoryGap},
+		{0, []uint64{3, 4, 5}, HistoryGap},
+		{9, []uint64{3, 4, 5}, HistoryReset},
+	}
+	for _, tt := range tests {
+		got, marker := h.replay(tt.since)
+		if len(seqs(got)) != len(tt.want) {
+			t.Errorf("since %d: got %v, want %v", tt.since, seqs(got), tt.want)
+		} else {
+			for i := range got {
+				if got[i].seq != tt.want[i] {
+					t.Errorf("since %d: got %v, want %v", tt.since, seqs(got), tt.want)
+					break
+				}
+			}
+		}
+		switch {
+		case tt.marker == "" && marker != nil:
+			t.Errorf("since %d: unexpected marker %+v", tt.since, marker)
+		case tt.marker != "" && (marker == nil || marker.History != tt.marker || marker.Since != tt.since):
+			t.Errorf("since %d: got marker %+v, want %s", tt.since, marker, tt.marker)
+		}
+	}
+}
+
+func TestHistoryReplayAged(t *testing.T) {
+	h := newEventHistory(3, time.Minute)
+	h.add(EventMsg{RawJSON: []byte(`{}`)})
+	h.add(EventMsg{RawJSON: []byte(`{}`)})
+	h.buf[0].at = time.Now().Add(-time.Hour)
+
+	got, marker := h.replay(0)
+	if len(got) != 1 || got[0].seq != 2 || marker == nil || marker.History != HistoryGap {
+		t.Errorf("got %v, %+v", seqs(got), marker)
+	}
+	if _, marker := h.replay(1); marker != nil {
+		t.Errorf("got marker %+v after the aged event", marker)
+	}
+}
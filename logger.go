@@ -0,0 +1,115 @@
+package gobaresip
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// LogLevel is the severity of a log record. The values match baresip's
+// enum log_level.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// LogAttr is a key value pair attached to a log record.
+type LogAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// LogRecord is a single log line of go-baresip or baresip.
+type LogRecord struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Attrs   []LogAttr
+}
+
+// LogHandler receives the log records. It follows the shape of slog.Handler,
+// so an adapter only needs to map levels and attributes.
+type LogHandler interface {
+	// Enabled reports whether records of level are handled at all.
+	Enabled(level LogLevel) bool
+	// Handle handles a record. Records of baresip itself are handled on the
+	// re main thread, so Handle should not block.
+	Handle(r LogRecord) error
+}
+
+// stdLogHandler writes to the standard logger.
+type stdLogHandler struct{}
+
+func (stdLogHandler) Enabled(LogLevel) bool {
+	return true
+}
+
+func (stdLogHandler) Handle(r LogRecord) error {
+	var sb strings.Builder
+	sb.WriteString(r.Message)
+	for _, a := range r.Attrs {
+		fmt.Fprintf(&sb, " %s=%v", a.Key, a.Value)
+	}
+	log.Println(sb.String())
+	return nil
+}
+
+func (b *Baresip) log(level LogLevel, msg string, attrs ...LogAttr) {
+	if !b.logger.Enabled(level) {
+		return
+	}
+	b.logger.Handle(LogRecord{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+		Attrs:   attrs,
+	})
+}
+
+// logBaresip handles a log line of baresip. AORs and Call-IDs which are known
+// and appear in the line are attached as attributes.
+func (b *Baresip) logBaresip(level LogLevel, msg string) {
+	if !b.logger.Enabled(level) {
+		return
+	}
+
+	msg = strings.TrimRight(msg, "\r\n")
+	attrs := []LogAttr{{Key: "source", Value: "baresip"}}
+
+	b.regs.mux.RLock()
+	for aor := range b.regs.regs {
+		if strings.Contains(msg, aor) {
+			attrs = append(attrs, LogAttr{Key: "aor", Value: aor})
+		}
+	}
+	b.regs.mux.RUnlock()
+
+	b.calls.mux.RLock()
+	for id := range b.calls.calls {
+		if strings.Contains(msg, id) {
+			attrs = append(attrs, LogAttr{Key: "call_id", Value: id})
+		}
+	}
+	b.calls.mux.RUnlock()
+
+	b.log(level, msg, attrs...)
+}
@@ -0,0 +1,120 @@
+package gobaresip
+
+import (
+	"context"
+	"sync"
+)
+
+// mainQueue holds the funcs waiting to run on the re main thread. mux also
+// guards the C queue, which must not be pushed to while it is set up or freed.
+type mainQueue struct {
+	mux    sync.Mutex
+	seq    int32
+	fns    map[int]func()
+	closed bool
+}
+
+func (q *mainQueue) run(id int) {
+	q.mux.Lock()
+	f, ok := q.fns[id]
+	delete(q.fns, id)
+	q.mux.Unlock()
+
+	if ok {
+		f()
+	}
+}
+
+// runOnMain queues f to run on the re main thread and returns immediately.
+// libre and baresip are not thread safe, every direct call into them from Go
+// has to go through here.
+func (b *Baresip) runOnMain(f func()) error {
+	q := &b.mainq
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.closed || b.stopped() {
+		return ErrStopped
+	}
+	q.seq++
+	id := int(q.seq)
+	q.fns[id] = f
+
+	if err := pushMain(id); err != nil {
+		delete(q.fns, id)
+		return err
+	}
+	return nil
+}
+
+// RunOnMain runs f on the re main thread and waits until it returned. This
+// allows calling libre and baresip APIs safely from any goroutine. If ctx is
+// done first, f may still run later. RunOnMain must not be called from f or
+// from a LogHandler, both run on the main thread and it would wait for itself.
+func (b *Baresip) RunOnMain(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	err := b.runOnMain(func() {
+		defer close(done)
+		f()
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-b.done:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// taskQueue runs funcs in order on its own goroutine. push never blocks, so
+// the re main thread and the ctrl_tcp reader can hand work off to it without
+// waiting for hooks or slow consumers.
+type taskQueue struct {
+	mux    sync.Mutex
+	fns    []func()
+	notify chan struct{}
+}
+
+func newTaskQueue() taskQueue {
+	return taskQueue{notify: make(chan struct{}, 1)}
+}
+
+func (q *taskQueue) push(f func()) {
+	q.mux.Lock()
+	q.fns = append(q.fns, f)
+	q.mux.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *taskQueue) runAll() {
+	q.mux.Lock()
+	fns := q.fns
+	q.fns = nil
+	q.mux.Unlock()
+
+	for _, f := range fns {
+		f()
+	}
+}
+
+// run runs the queued funcs until quit is closed and the queue is drained.
+func (q *taskQueue) run(quit <-chan struct{}) {
+	for {
+		select {
+		case <-q.notify:
+			q.runAll()
+		case <-quit:
+			q.runAll()
+			return
+		}
+	}
+}
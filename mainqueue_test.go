@@ -0,0 +1,14 @@
+package gobaresip
+
+import "testing"
+
+func TestRunOnMainAfterClose(t *testing.T) {
+	b := newBaresip()
+	b.mainq.closed = true
+	if err := b.runOnMain(func() {}); err != ErrStopped {
+		t.Errorf("got %v, want %v", err, ErrStopped)
+	}
+	if len(b.mainq.fns) != 0 {
+		t.Error("func was queued")
+	}
+}
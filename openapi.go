@@ -0,0 +1,229 @@
+package gobaresip
+
+import "net/http"
+
+// openAPISpec describes the REST API of WebHandler. Keep it in sync with
+// rest.go.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "go-baresip",
+    "description": "Call control of a baresip instance. Paths are relative to where WebHandler is mounted.",
+    "version": "1.0.0"
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"},
+      "basic": {"type": "http", "scheme": "basic"}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {"error": {"type": "string"}}
+      },
+      "Call": {
+        "type": "object",
+        "required": ["id", "state", "created", "updated"],
+        "properties": {
+          "id": {"type": "string"},
+          "account_aor": {"type": "string"},
+          "peer_uri": {"type": "string"},
+          "peer_displayname": {"type": "string"},
+          "direction": {"type": "string", "enum": ["incoming", "outgoing"]},
+          "state": {"type": "string", "enum": ["incoming", "outgoing", "ringing", "progress", "established", "closed"]},
+          "close_reason": {"type": "string"},
+          "created": {"type": "string", "format": "date-time"},
+          "established": {"type": "string", "format": "date-time"},
+          "updated": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Dial": {
+        "type": "object",
+        "required": ["uri"],
+        "properties": {
+          "uri": {"type": "string", "example": "sip:bob@example.com"},
+          "account": {"type": "string", "description": "AOR of the User-Agent to call from, the current one if empty."}
+        }
+      },
+      "Dialed": {
+        "type": "object",
+        "properties": {"id": {"type": "string"}}
+      },
+      "DTMF": {
+        "type": "object",
+        "required": ["digits"],
+        "properties": {"digits": {"type": "string", "example": "123#"}}
+      },
+      "Registration": {
+        "type": "object",
+        "required": ["aor", "state", "healthy", "consecutive_failures"],
+        "properties": {
+          "aor": {"type": "string"},
+          "state": {"type": "string", "enum": ["registering", "registered", "failed", "unregistering"]},
+          "healthy": {"type": "boolean"},
+          "fallback": {"type": "boolean"},
+          "last_success": {"type": "string", "format": "date-time"},
+          "last_failure": {"type": "string", "format": "date-time"},
+          "last_failure_reason": {"type": "string"},
+          "consecutive_failures": {"type": "integer"},
+          "expires": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Account": {
+        "type": "object",
+        "required": ["aor"],
+        "properties": {
+          "displayname": {"type": "string"},
+          "aor": {"type": "string", "example": "sip:alice@example.com"},
+          "transport": {"type": "string", "enum": ["udp", "tcp", "tls"]},
+          "auth_user": {"type": "string"},
+          "auth_pass": {"type": "string", "writeOnly": true},
+          "outbound": {"type": "string"},
+          "regint": {"type": "integer", "description": "0 uses the default, -1 disables registration."},
+          "mediaenc": {"type": "string", "enum": ["srtp", "srtp-mand", "srtp-mandf", "dtls_srtp", "zrtp"]},
+          "audio_codecs": {"type": "array", "items": {"type": "string"}},
+          "answermode": {"type": "string", "enum": ["manual", "early", "auto"]},
+          "stunserver": {"type": "string"},
+          "stunuser": {"type": "string"},
+          "stunpass": {"type": "string", "writeOnly": true},
+          "mwi": {"type": "boolean"},
+          "params": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      }
+    },
+    "responses": {
+      "BadRequest": {"description": "Invalid request body.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Missing or wrong credentials."},
+      "TooLarge": {"description": "The request body exceeds 64 KiB.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Forbidden": {"description": "The client is an observer.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "Unknown call.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Failed": {"description": "baresip rejected the command.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unavailable": {"description": "ctrl_tcp is not connected or baresip is shutting down.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Timeout": {"description": "baresip didn't respond in time.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  },
+  "security": [{}, {"bearer": []}, {"basic": []}],
+  "paths": {
+    "/calls": {
+      "get": {
+        "summary": "List the active calls",
+        "responses": {
+          "200": {"description": "Active calls.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Call"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      },
+      "post": {
+        "summary": "Dial a call",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Dial"}}}},
+        "responses": {
+          "201": {"description": "Call placed.", "headers": {"Location": {"schema": {"type": "string"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Dialed"}}}},
+          "202": {"description": "Call placed, baresip didn't report its ID.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Dialed"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "422": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      }
+    },
+    "/calls/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get a call",
+        "responses": {
+          "200": {"description": "The call.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Call"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "delete": {
+        "summary": "Hang up a call",
+        "responses": {
+          "204": {"description": "Hangup sent."},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      }
+    },
+    "/calls/{id}/dtmf": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "summary": "Send DTMF digits to a call",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DTMF"}}}},
+        "responses": {
+          "204": {"description": "Digits sent."},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      }
+    },
+    "/registrations": {
+      "get": {
+        "summary": "List the registration state per AOR",
+        "responses": {
+          "200": {"description": "Registrations.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Registration"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "summary": "Stream the events as Server-Sent Events",
+        "description": "Every event carries its sequence number as id and in the seq member of the JSON data. Reconnecting clients send Last-Event-ID and get the missed events from the history first. If some of them are not kept anymore, or Last-Event-ID is unknown, a history event with data {\"history\": \"gap\" or \"reset\", \"since\": n} comes first.",
+        "parameters": [
+          {"name": "type", "in": "query", "description": "Event types, e.g. CALL_INCOMING. Repeated or comma separated.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "class", "in": "query", "description": "Event classes, e.g. call.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "aor", "in": "query", "description": "Account AORs.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "call_id", "in": "query", "description": "Call IDs.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true},
+          {"name": "since", "in": "query", "description": "Like Last-Event-ID, for clients which can't set headers.", "schema": {"type": "integer"}},
+          {"name": "Last-Event-ID", "in": "header", "description": "Sequence number of the last received event.", "schema": {"type": "integer"}}
+        ],
+        "responses": {
+          "200": {"description": "Event stream.", "content": {"text/event-stream": {"schema": {"type": "string"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/accounts": {
+      "post": {
+        "summary": "Add a User-Agent",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Account"}}}},
+        "responses": {
+          "201": {"description": "Account added, without passwords.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Account"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/TooLarge"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "422": {"$ref": "#/components/responses/Failed"},
+          "503": {"$ref": "#/components/responses/Unavailable"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      }
+    }
+  }
+}
+`
+
+// serveOpenAPI serves the OpenAPI spec of the REST API.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
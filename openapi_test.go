@@ -0,0 +1,73 @@
+package gobaresip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+// newRESTTest returns the REST API of a stopped instance with the incoming
+// call c1, so no request waits for baresip.
+func newRESTTest() (*Baresip, *http.ServeMux) {
+	b := newBaresip()
+	b.hub = newWsHub(b)
+	close(b.quit)
+	b.calls.update(EventMsg{ID: "c1", EventType: EventCallIncoming})
+
+	mux := http.NewServeMux()
+	b.registerREST(mux)
+	return b, mux
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	b, mux := newRESTTest()
+	h := b.wsAuthenticate(mux)
+	routes := b.restRoutes()
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+	documented := map[string]bool{"/openapi.json": true}
+	for path, ops := range spec.Paths {
+		target := strings.Replace(path, "{id}", "c1", 1)
+		_, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, target, nil))
+		if _, ok := routes[pattern]; !ok {
+			t.Errorf("%s is not routed", path)
+			continue
+		}
+		documented[pattern] = true
+
+		var allow []string
+		for _, m := range methods {
+			if _, ok := ops[strings.ToLower(m)]; ok {
+				allow = append(allow, m)
+			}
+		}
+		for _, m := range methods {
+			_, inSpec := ops[strings.ToLower(m)]
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(m, target, nil))
+			switch {
+			case inSpec && w.Code == http.StatusMethodNotAllowed:
+				t.Errorf("%s %s is documented but not allowed", m, path)
+			case !inSpec && w.Code != http.StatusMethodNotAllowed:
+				t.Errorf("%s %s is not documented but got %d", m, path, w.Code)
+			case !inSpec && w.Header().Get("Allow") != strings.Join(allow, ", "):
+				t.Errorf("%s %s: Allow %q, want %q", m, path, w.Header().Get("Allow"), strings.Join(allow, ", "))
+			}
+		}
+	}
+	for pattern := range routes {
+		if !documented[pattern] {
+			t.Errorf("%s is not documented", pattern)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package gobaresip
 
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+)
+
 // SetOption takes one or more option function and applies them in order to Baresip.
 func (b *Baresip) SetOption(options ...func(*Baresip) error) error {
 	for _, opt := range options {
@@ -18,7 +24,8 @@ func SetCtrlTCPAddr(opt string) func(*Baresip) error {
 	}
 }
 
-// SetWsAddr sets the ws address.
+// SetWsAddr sets the ws address. If set, New binds it and serves WebHandler on
+// it. Leave it empty to mount WebHandler on your own mux instead.
 func SetWsAddr(opt string) func(*Baresip) error {
 	return func(b *Baresip) error {
 		b.wsAddr = opt
@@ -26,6 +33,106 @@ func SetWsAddr(opt string) func(*Baresip) error {
 	}
 }
 
+// SetWsTLS serves the web UI and the websocket of SetWsAddr over HTTPS with
+// the certificate from certFile and keyFile. The files are checked for changes
+// every few seconds, so renewed certificates are picked up without restart.
+// New fails if SetWsAddr is not set.
+func SetWsTLS(certFile, keyFile string) func(*Baresip) error {
+	return func(b *Baresip) error {
+		cr, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		b.wsCert = cr
+		return nil
+	}
+}
+
+// SetWsTLSConfig serves the web UI and the websocket of SetWsAddr over HTTPS
+// with opt. Combined with SetWsTLS the certificate of SetWsTLS is used. New
+// fails if SetWsAddr is not set.
+func SetWsTLSConfig(opt *tls.Config) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if opt == nil {
+			return fmt.Errorf("nil ws tls config")
+		}
+		b.wsTLS = opt
+		return nil
+	}
+}
+
+// SetWsBearerToken authenticates web clients which send token as bearer token
+// or in the access_token parameter and grants them perm. Auth options add up,
+// the first one accepting a request decides the permission.
+func SetWsBearerToken(token string, perm WsPermission) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if token == "" {
+			return fmt.Errorf("empty ws bearer token")
+		}
+		b.wsAuth = append(b.wsAuth, wsBearerAuth(token, perm))
+		return nil
+	}
+}
+
+// SetWsBasicAuth authenticates web clients with HTTP basic auth and grants
+// them perm.
+func SetWsBasicAuth(user, pass string, perm WsPermission) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if user == "" || pass == "" {
+			return fmt.Errorf("empty ws basic auth credentials")
+		}
+		b.wsAuth = append(b.wsAuth, wsBasicAuth(user, pass, perm))
+		b.wsBasic = true
+		return nil
+	}
+}
+
+// SetWsAuthFunc authenticates web clients with a custom function.
+func SetWsAuthFunc(opt WsAuthFunc) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if opt == nil {
+			return fmt.Errorf("nil ws auth func")
+		}
+		b.wsAuth = append(b.wsAuth, opt)
+		return nil
+	}
+}
+
+// SetWsOrigins sets the allowed origins of websocket clients, e.g.
+// "https://pbx.example.com" or "*" for any. By default the origin must match
+// the host.
+func SetWsOrigins(opt ...string) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.wsOrigins = opt
+		return nil
+	}
+}
+
+// SetWsHistory sets how many recent events the websocket hub keeps and how
+// long. New clients get them replayed, or only the ones after the sequence
+// number of the since parameter, e.g. /ws?since=42. A size of 0 disables the
+// history, a maxAge of 0 keeps events until they are pushed out. The default
+// is 100 events for 10 minutes.
+func SetWsHistory(size int, maxAge time.Duration) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if size < 0 {
+			return fmt.Errorf("negative ws history size %d", size)
+		}
+		b.historySize = size
+		b.historyAge = maxAge
+		return nil
+	}
+}
+
+// SetPprofAddr sets the address for the runtime profiles. If set, New binds it
+// and serves ProfileHandler on it. Profiling is disabled by default.
+func SetPprofAddr(opt string) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.pprofAddr = opt
+		return nil
+	}
+}
+
 // SetConfigPath sets the config path.
 func SetConfigPath(opt string) func(*Baresip) error {
 	return func(b *Baresip) error {
@@ -57,3 +164,97 @@ func SetUserAgent(opt string) func(*Baresip) error {
 		return nil
 	}
 }
+
+// SetCmdQueueSize sets how many commands are queued while the ctrl_tcp
+// connection is down. They are sent once it is back. By default commands
+// fail fast.
+func SetCmdQueueSize(opt int) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.cmdQueueSize = opt
+		return nil
+	}
+}
+
+// SetEventPolicy sets how events are delivered to the EventMsg channel when
+// it is full. The default OverflowDropOldest turns the channel into a ring
+// buffer, OverflowDropNewest drops the event and OverflowBlock stalls reading
+// from baresip until the channel is read. With SetInProcessEvents blocked
+// events pile up in memory instead.
+func SetEventPolicy(opt OverflowPolicy) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.eventPolicy = opt
+		return nil
+	}
+}
+
+// SetResponsePolicy sets how responses are delivered to the ResponseMsg
+// channel when it is full. See SetEventPolicy for the policies.
+func SetResponsePolicy(opt OverflowPolicy) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.responsePolicy = opt
+		return nil
+	}
+}
+
+// SetReadyWaitRegister sets whether Ready also waits until all UAs which
+// register are registered. UAs without registration, e.g. regint=0, are skipped.
+func SetReadyWaitRegister(opt bool) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.waitRegister = opt
+		return nil
+	}
+}
+
+// SetInProcessEvents sets whether events are taken directly from baresip's
+// event handler instead of the ctrl_tcp connection. The re main thread only
+// queues the events, they are delivered from a separate goroutine like with
+// ctrl_tcp.
+func SetInProcessEvents(opt bool) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.nativeEvents = opt
+		return nil
+	}
+}
+
+// SetInProcessCommands sets whether commands run directly through baresip's
+// command subsystem instead of the ctrl_tcp connection. Together with
+// SetInProcessEvents the ctrl_tcp module is not needed anymore.
+func SetInProcessCommands(opt bool) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.nativeCmds = opt
+		return nil
+	}
+}
+
+// SetConfig sets a config which is used instead of the config file in the
+// config path. Its Accounts are created on startup.
+func SetConfig(opt *Config) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.config = opt
+		return nil
+	}
+}
+
+// SetCtrlTCPAutoPort sets whether ctrl_tcp listens on a free local port
+// instead of a fixed address. The port is put into the config and the peer
+// is verified to be this instance, so multiple processes can run side by side.
+// Commands are held back until the peer is verified.
+func SetCtrlTCPAutoPort(opt bool) func(*Baresip) error {
+	return func(b *Baresip) error {
+		b.ctrlAutoPort = opt
+		return nil
+	}
+}
+
+// SetLogger sets the handler for the log records of go-baresip and baresip.
+// By default go-baresip logs to the standard logger and baresip to stdout.
+func SetLogger(opt LogHandler) func(*Baresip) error {
+	return func(b *Baresip) error {
+		if opt == nil {
+			return fmt.Errorf("nil log handler")
+		}
+		b.logger = opt
+		b.customLogger = true
+		return nil
+	}
+}
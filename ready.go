@@ -0,0 +1,113 @@
+package gobaresip
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStopped is returned when waiting for a Baresip instance which stopped.
+var ErrStopped = errors.New("baresip stopped")
+
+// Ready returns a channel which is closed once the re main loop is running,
+// ctrl_tcp is connected to this instance and, with SetReadyWaitRegister, all UAs
+// which register are registered.
+func (b *Baresip) Ready() <-chan struct{} {
+	return b.ready
+}
+
+// WaitReady blocks until Ready fires, ctx is done or Run returned.
+func (b *Baresip) WaitReady(ctx context.Context) error {
+	select {
+	case <-b.ready:
+		return nil
+	case <-b.done:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel which is closed when Run returns.
+func (b *Baresip) Done() <-chan struct{} {
+	return b.done
+}
+
+// quitContext returns a context which is canceled when Baresip is stopped.
+func (b *Baresip) quitContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-b.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// signalReady closes ready once a command made the round trip through
+// ctrl_tcp and the main loop, which proves both are up. The peer of ctrl_tcp
+// is verified first, commands are held back until then.
+func (b *Baresip) signalReady() {
+	ctx, cancel := b.quitContext()
+	defer cancel()
+
+	if b.verifyPeer() {
+		for {
+			pctx, pcancel := context.WithTimeout(ctx, time.Second)
+			err := b.verifyCtrl(pctx)
+			pcancel()
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if !verifyRetry(err) {
+				b.log(LevelError, err.Error())
+				b.stopCtrl()
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	var info ResponseMsg
+	for {
+		pctx, pcancel := context.WithTimeout(ctx, time.Second)
+		r, err := b.Do(pctx, "reginfo", "")
+		pcancel()
+		info = r
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if b.waitRegister {
+		// Wait for the UAs which register, not only for those which already
+		// sent an event.
+		aors, ok := parseRegInfo(info.Data)
+		switch {
+		case !ok:
+			b.log(LevelWarn, "can't tell the UAs which register, not waiting for them",
+				LogAttr{Key: "reginfo", Value: info.Data})
+		case len(aors) > 0:
+			if err := b.WaitRegistered(ctx, aors...); err != nil {
+				b.log(LevelWarn, err.Error())
+				return
+			}
+		}
+	}
+
+	close(b.ready)
+}
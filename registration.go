@@ -0,0 +1,261 @@
+package gobaresip
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRegInt is baresip's registration interval if an account has none.
+const defaultRegInt = 3600
+
+// RegState is the registration state of a User-Agent.
+type RegState int
+
+const (
+	RegStateRegistering RegState = iota
+	RegStateRegistered
+	RegStateFailed
+	RegStateUnregistering
+)
+
+var regStateNames = [...]string{
+	RegStateRegistering:   "registering",
+	RegStateRegistered:    "registered",
+	RegStateFailed:        "failed",
+	RegStateUnregistering: "unregistering",
+}
+
+func (s RegState) String() string {
+	if s >= 0 && int(s) < len(regStateNames) {
+		return regStateNames[s]
+	}
+	return "unknown"
+}
+
+// Registration is a snapshot of the registration state of an AOR.
+type Registration struct {
+	AOR   string
+	State RegState
+	// Fallback is set if the state was reported for a fallback account.
+	Fallback bool
+
+	LastSuccess         time.Time
+	LastFailure         time.Time
+	LastFailureReason   string
+	ConsecutiveFailures int
+	// Expires is when the last successful registration runs out, zero if
+	// unknown.
+	Expires time.Time
+}
+
+// Healthy reports whether the AOR is registered and the registration
+// has not expired.
+func (r Registration) Healthy() bool {
+	return r.State == RegStateRegistered &&
+		(r.Expires.IsZero() || time.Now().Before(r.Expires))
+}
+
+// registrations tracks the registration state per AOR from baresip's events.
+type registrations struct {
+	mux     sync.RWMutex
+	regs    map[string]*Registration
+	hooks   []func(r Registration, prev RegState)
+	changed chan struct{}
+}
+
+func (b *Baresip) updateRegistration(e EventMsg) {
+	if e.AccountAOR == "" || !e.EventType.IsRegisterEvent() {
+		return
+	}
+
+	now := time.Now()
+	rs := &b.regs
+
+	rs.mux.Lock()
+	r, ok := rs.regs[e.AccountAOR]
+	if !ok {
+		r = &Registration{AOR: e.AccountAOR}
+		rs.regs[e.AccountAOR] = r
+	}
+	prev := r.State
+
+	switch e.EventType {
+	case EventRegistering:
+		r.State = RegStateRegistering
+	case EventUnregistering:
+		r.State = RegStateUnregistering
+	case EventRegisterOK, EventFallbackOK:
+		r.State = RegStateRegistered
+		r.Fallback = e.EventType == EventFallbackOK
+		r.LastSuccess = now
+		r.ConsecutiveFailures = 0
+		r.Expires = time.Time{}
+		if regint := b.regInt(e.AccountAOR); regint > 0 {
+			r.Expires = now.Add(time.Duration(regint) * time.Second)
+		}
+	case EventRegisterFail, EventFallbackFail:
+		r.State = RegStateFailed
+		r.Fallback = e.EventType == EventFallbackFail
+		r.LastFailure = now
+		r.LastFailureReason = e.Param
+		r.ConsecutiveFailures++
+	}
+
+	if !ok {
+		prev = r.State
+	}
+
+	snap := *r
+	hooks := rs.hooks
+	close(rs.changed)
+	rs.changed = make(chan struct{})
+	rs.mux.Unlock()
+
+	if ok && prev == snap.State && snap.State != RegStateFailed {
+		return
+	}
+	b.hookq.push(func() {
+		for _, f := range hooks {
+			f(snap, prev)
+		}
+	})
+}
+
+// regInt returns the registration interval of aor in seconds, 0 for none or
+// if aor wasn't added by us, e.g. from baresip's accounts file.
+func (b *Baresip) regInt(aor string) int {
+	b.accounts.mux.RLock()
+	a, ok := b.accounts.m[aor]
+	b.accounts.mux.RUnlock()
+	switch {
+	case !ok || a.RegInt < 0:
+		return 0
+	case a.RegInt == 0:
+		return defaultRegInt
+	}
+	return a.RegInt
+}
+
+func (b *Baresip) removeRegistration(aor string) {
+	b.regs.mux.Lock()
+	delete(b.regs.regs, aor)
+	close(b.regs.changed)
+	b.regs.changed = make(chan struct{})
+	b.regs.mux.Unlock()
+}
+
+// Registrations returns the registration state of all AORs ordered by AOR.
+func (b *Baresip) Registrations() []Registration {
+	b.regs.mux.RLock()
+	regs := make([]Registration, 0, len(b.regs.regs))
+	for _, r := range b.regs.regs {
+		regs = append(regs, *r)
+	}
+	b.regs.mux.RUnlock()
+
+	sort.Slice(regs, func(i, j int) bool {
+		return regs[i].AOR < regs[j].AOR
+	})
+	return regs
+}
+
+// Registration returns the registration state of aor.
+func (b *Baresip) Registration(aor string) (Registration, bool) {
+	b.regs.mux.RLock()
+	defer b.regs.mux.RUnlock()
+	if r, ok := b.regs.regs[aor]; ok {
+		return *r, true
+	}
+	return Registration{}, false
+}
+
+// OnRegistrationChange registers f to be called whenever the registration
+// state of an AOR changes or another registration attempt fails. A new AOR
+// reports its first state with prev equal to its current state. The hooks
+// run like those of OnCallStateChange.
+func (b *Baresip) OnRegistrationChange(f func(r Registration, prev RegState)) {
+	b.regs.mux.Lock()
+	b.regs.hooks = append(b.regs.hooks, f)
+	b.regs.mux.Unlock()
+}
+
+// WaitRegistered blocks until all aors are registered. Without aors it waits
+// until at least one AOR is known and all known AORs are registered.
+func (b *Baresip) WaitRegistered(ctx context.Context, aors ...string) error {
+	for {
+		b.regs.mux.RLock()
+		done := b.registered(aors)
+		changed := b.regs.changed
+		b.regs.mux.RUnlock()
+		if done {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+var regInfoHeader = regexp.MustCompile(`--- User Agents \((\d+)\) ---`)
+
+// parseRegInfo returns the AORs of the UAs which register from the output of
+// reginfo. Each UA is listed with its AOR followed by the status of its
+// registrations, UAs without registration have none.
+func parseRegInfo(data string) ([]string, bool) {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		m := regInfoHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		uas := lines[i+1:]
+		if len(uas) < n {
+			return nil, false
+		}
+
+		aors := []string{}
+		for _, ua := range uas[:n] {
+			f := strings.Fields(strings.TrimPrefix(strings.TrimSpace(ua), ">"))
+			if len(f) == 0 {
+				return nil, false
+			}
+			if len(f) > 1 {
+				aors = append(aors, f[0])
+			}
+		}
+		return aors, true
+	}
+	return nil, false
+}
+
+// registered must be called with regs.mux held.
+func (b *Baresip) registered(aors []string) bool {
+	if len(aors) == 0 {
+		if len(b.regs.regs) == 0 {
+			return false
+		}
+		for _, r := range b.regs.regs {
+			if r.State != RegStateRegistered {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, aor := range aors {
+		r, ok := b.regs.regs[Account{AOR: aor}.aor()]
+		if !ok || r.State != RegStateRegistered {
+			return false
+		}
+	}
+	return true
+}
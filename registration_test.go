@@ -0,0 +1,187 @@
+package gobaresip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRegInfo(t *testing.T) {
+	tests := []struct {
+		data string
+		aors []string
+		ok   bool
+	}{
+		{"\n--- User Agents (0) ---\n\n", nil, true},
+		{
+			"\n--- User Agents (3) ---\n" +
+				"> sip:alice@example.com                      \x1b[32mOK \x1b[;m sip:proxy.example.com\n" +
+				"  sip:bob@example.com                        \x1b[31mERR\x1b[;m \n" +
+				"  sip:local@127.0.0.1                        \n\n",
+			[]string{"sip:alice@example.com", "sip:bob@example.com"}, true,
+		},
+		{"\n--- User Agents (2) ---\n  sip:alice@example.com   OK\n", nil, false},
+		{"something else", nil, false},
+	}
+	for _, tt := range tests {
+		aors, ok := parseRegInfo(tt.data)
+		if ok != tt.ok || len(aors) != len(tt.aors) {
+			t.Errorf("%q: got %v, %v", tt.data, aors, ok)
+			continue
+		}
+		for i := range aors {
+			if aors[i] != tt.aors[i] {
+				t.Errorf("%q: got %v, want %v", tt.data, aors, tt.aors)
+			}
+		}
+	}
+}
+
+func TestUpdateRegistration(t *testing.T) {
+	const aor = "sip:alice@example.com"
+	b := newBaresip()
+	b.accounts.m[aor] = Account{AOR: aor, RegInt: 600}
+
+	tests := []struct {
+		typ      EventType
+		param    string
+		state    RegState
+		fallback bool
+		failures int
+	}{
+		{EventRegistering, "", RegStateRegistering, false, 0},
+		{EventRegisterFail, "408 Request Timeout", RegStateFailed, false, 1},
+		{EventRegisterFail, "408 Request Timeout", RegStateFailed, false, 2},
+		{EventRegisterOK, "", RegStateRegistered, false, 0},
+		{EventFallbackFail, "503", RegStateFailed, true, 1},
+		{EventFallbackOK, "", RegStateRegistered, true, 0},
+		{EventUnregistering, "", RegStateUnregistering, true, 0},
+	}
+	for _, tt := range tests {
+		b.updateRegistration(EventMsg{AccountAOR: aor, EventType: tt.typ, Param: tt.param})
+		r, ok := b.Registration(aor)
+		if !ok || r.State != tt.state || r.Fallback != tt.fallback || r.ConsecutiveFailures != tt.failures {
+			t.Errorf("%v: got %+v", tt.typ, r)
+		}
+		if tt.typ == EventRegisterFail && r.LastFailureReason != tt.param {
+			t.Errorf("%v: got reason %q", tt.typ, r.LastFailureReason)
+		}
+	}
+
+	r, _ := b.Registration(aor)
+	if d := time.Until(r.Expires); d <= 590*time.Second || d > 600*time.Second {
+		t.Errorf("got expiry in %v, want 600s", d)
+	}
+}
+
+func TestRegistrationExpires(t *testing.T) {
+	tests := []struct {
+		account *Account
+		expires time.Duration
+	}{
+		{&Account{RegInt: 60}, 60 * time.Second},
+		{&Account{}, defaultRegInt * time.Second},
+		{&Account{RegInt: -1}, 0},
+		// Accounts from the accounts file have an unknown interval.
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		const aor = "sip:alice@example.com"
+		b := newBaresip()
+		if tt.account != nil {
+			tt.account.AOR = aor
+			b.accounts.m[aor] = *tt.account
+		}
+		b.updateRegistration(EventMsg{AccountAOR: aor, EventType: EventRegisterOK})
+
+		r, _ := b.Registration(aor)
+		if tt.expires == 0 {
+			if !r.Expires.IsZero() {
+				t.Errorf("%+v: got expiry %v, want none", tt.account, r.Expires)
+			}
+		} else if d := time.Until(r.Expires); d <= tt.expires-10*time.Second || d > tt.expires {
+			t.Errorf("%+v: got expiry in %v, want %v", tt.account, d, tt.expires)
+		}
+		if !r.Healthy() {
+			t.Errorf("%+v: registration not healthy", tt.account)
+		}
+	}
+}
+
+func TestWaitRegistered(t *testing.T) {
+	b := newBaresip()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.WaitRegistered(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v without AORs, want %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.WaitRegistered(context.Background(), "sip:alice@example.com", "sip:bob@example.com")
+	}()
+
+	b.updateRegistration(EventMsg{AccountAOR: "sip:alice@example.com", EventType: EventRegisterOK})
+	b.updateRegistration(EventMsg{AccountAOR: "sip:bob@example.com", EventType: EventRegisterFail})
+	select {
+	case err := <-done:
+		t.Fatalf("returned %v before bob registered", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.updateRegistration(EventMsg{AccountAOR: "sip:bob@example.com", EventType: EventRegisterOK})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after all AORs registered")
+	}
+}
+
+func TestRegistrationHooks(t *testing.T) {
+	const aor = "sip:alice@example.com"
+	type change struct {
+		state, prev RegState
+	}
+	b := newBaresip()
+	got := make(chan change, 8)
+	b.OnRegistrationChange(func(r Registration, prev RegState) {
+		got <- change{r.State, prev}
+	})
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go b.hookq.run(quit)
+
+	for _, typ := range []EventType{
+		EventRegistering, EventRegistering, EventRegisterOK, EventRegisterOK,
+		EventRegisterFail, EventRegisterFail,
+	} {
+		b.updateRegistration(EventMsg{AccountAOR: aor, EventType: typ})
+	}
+
+	// Repeated states are only reported for failures.
+	want := []change{
+		{RegStateRegistering, RegStateRegistering},
+		{RegStateRegistered, RegStateRegistering},
+		{RegStateFailed, RegStateRegistered},
+		{RegStateFailed, RegStateFailed},
+	}
+	for _, w := range want {
+		select {
+		case c := <-got:
+			if c != w {
+				t.Errorf("got %v, want %v", c, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("hook not called for %v", w)
+		}
+	}
+	select {
+	case c := <-got:
+		t.Errorf("unexpected change %v", c)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
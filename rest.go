@@ -0,0 +1,335 @@
+package gobaresip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// restTimeout limits how long a REST request waits for baresip.
+const restTimeout = 30 * time.Second
+
+// restMaxBody limits the size of a REST request body.
+const restMaxBody = 64 << 10
+
+// restCall is the JSON representation of a Call in the REST API.
+type restCall struct {
+	ID              string     `json:"id"`
+	AccountAOR      string     `json:"account_aor,omitempty"`
+	PeerURI         string     `json:"peer_uri,omitempty"`
+	PeerDisplayname string     `json:"peer_displayname,omitempty"`
+	Direction       string     `json:"direction,omitempty"`
+	State           string     `json:"state"`
+	CloseReason     string     `json:"close_reason,omitempty"`
+	Created         time.Time  `json:"created"`
+	Established     *time.Time `json:"established,omitempty"`
+	Updated         time.Time  `json:"updated"`
+}
+
+func newRestCall(c Call) restCall {
+	rc := restCall{
+		ID:              c.ID,
+		AccountAOR:      c.AccountAOR,
+		PeerURI:         c.PeerURI,
+		PeerDisplayname: c.PeerDisplayname,
+		Direction:       c.Direction,
+		State:           c.State.String(),
+		CloseReason:     c.CloseReason,
+		Created:         c.Created,
+		Updated:         c.Updated,
+	}
+	if !c.Established.IsZero() {
+		rc.Established = &c.Established
+	}
+	return rc
+}
+
+// restRegistration is the JSON representation of a Registration in the REST
+// API.
+type restRegistration struct {
+	AOR                 string     `json:"aor"`
+	State               string     `json:"state"`
+	Healthy             bool       `json:"healthy"`
+	Fallback            bool       `json:"fallback,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastFailureReason   string     `json:"last_failure_reason,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Expires             *time.Time `json:"expires,omitempty"`
+}
+
+func optTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newRestRegistration(r Registration) restRegistration {
+	return restRegistration{
+		AOR:                 r.AOR,
+		State:               r.State.String(),
+		Healthy:             r.Healthy(),
+		Fallback:            r.Fallback,
+		LastSuccess:         optTime(r.LastSuccess),
+		LastFailure:         optTime(r.LastFailure),
+		LastFailureReason:   r.LastFailureReason,
+		ConsecutiveFailures: r.ConsecutiveFailures,
+		Expires:             optTime(r.Expires),
+	}
+}
+
+type restDial struct {
+	URI     string `json:"uri"`
+	Account string `json:"account,omitempty"`
+}
+
+type restDialed struct {
+	ID string `json:"id,omitempty"`
+}
+
+type restDTMF struct {
+	Digits string `json:"digits"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// restRoutes maps the ServeMux patterns of the REST API to their handlers.
+// All but /openapi.json are described in openAPISpec.
+func (b *Baresip) restRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/calls":         b.restCalls,
+		"/calls/":        b.restCall,
+		"/registrations": b.restRegistrations,
+		"/accounts":      b.restAccounts,
+		"/events":        b.serveEvents,
+		"/openapi.json":  serveOpenAPI,
+	}
+}
+
+func (b *Baresip) registerREST(mux *http.ServeMux) {
+	for pattern, h := range b.restRoutes() {
+		mux.HandleFunc(pattern, h)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, restError{Error: msg})
+}
+
+// writeCmdError maps the error of a command to a status code.
+func writeCmdError(w http.ResponseWriter, err error) {
+	var ce *CommandError
+	switch {
+	case errors.As(err, &ce):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, err.Error())
+	case errors.Is(err, ErrCtrlNotConnected), errors.Is(err, ErrCtrlConnLost),
+		errors.Is(err, ErrShutdown), errors.Is(err, context.Canceled):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// restOperator reports whether the client may change state and answers
+// with 403 otherwise.
+func restOperator(w http.ResponseWriter, r *http.Request) bool {
+	if wsPermission(r) != WsOperator {
+		writeError(w, http.StatusForbidden, errWsDenied.Error())
+		return false
+	}
+	return true
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, restMaxBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// restContext returns the context for the baresip commands of a request.
+func restContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), restTimeout)
+}
+
+// restCalls serves GET and POST /calls.
+func (b *Baresip) restCalls(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		calls := b.Calls()
+		out := make([]restCall, 0, len(calls))
+		for _, c := range calls {
+			out = append(out, newRestCall(c))
+		}
+		writeJSON(w, http.StatusOK, out)
+	case http.MethodPost:
+		if !restOperator(w, r) {
+			return
+		}
+		var req restDial
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.URI == "" {
+			writeError(w, http.StatusBadRequest, "missing uri")
+			return
+		}
+
+		ctx, cancel := restContext(r)
+		defer cancel()
+		id, err := b.Dial(ctx, req.Account, req.URI)
+		if err != nil {
+			writeCmdError(w, err)
+			return
+		}
+		if id == "" {
+			// The call was placed but baresip didn't tell its ID.
+			writeJSON(w, http.StatusAccepted, restDialed{})
+			return
+		}
+		w.Header().Set("Location", "calls/"+url.PathEscape(id))
+		writeJSON(w, http.StatusCreated, restDialed{ID: id})
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// restCall serves GET and DELETE /calls/{id} and POST /calls/{id}/dtmf.
+func (b *Baresip) restCall(w http.ResponseWriter, r *http.Request) {
+	// Call-IDs may contain slashes, so the whole rest is tried first.
+	rest := strings.TrimPrefix(r.URL.Path, "/calls/")
+	action := ""
+	c, ok := b.Call(rest)
+	if i := strings.LastIndex(rest, "/"); !ok && i >= 0 {
+		action = rest[i+1:]
+		c, ok = b.Call(rest[:i])
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "call not found")
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, newRestCall(c))
+		case http.MethodDelete:
+			if !restOperator(w, r) {
+				return
+			}
+			ctx, cancel := restContext(r)
+			defer cancel()
+			if err := c.Hangup(ctx); err != nil {
+				writeCmdError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, "GET, DELETE")
+		}
+	case "dtmf":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		if !restOperator(w, r) {
+			return
+		}
+		var req restDTMF
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Digits == "" {
+			writeError(w, http.StatusBadRequest, "missing digits")
+			return
+		}
+		ctx, cancel := restContext(r)
+		defer cancel()
+		if err := c.SendDTMF(ctx, req.Digits); err != nil {
+			writeCmdError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// restRegistrations serves GET /registrations.
+func (b *Baresip) restRegistrations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	regs := b.Registrations()
+	out := make([]restRegistration, 0, len(regs))
+	for _, reg := range regs {
+		out = append(out, newRestRegistration(reg))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// restAccounts serves POST /accounts.
+func (b *Baresip) restAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !restOperator(w, r) {
+		return
+	}
+	var a Account
+	if !decodeJSON(w, r, &a) {
+		return
+	}
+	if a.AOR == "" {
+		writeError(w, http.StatusBadRequest, "missing aor")
+		return
+	}
+	if _, err := a.Line(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := restContext(r)
+	defer cancel()
+	if err := b.AddAccount(ctx, a); err != nil {
+		writeCmdError(w, err)
+		return
+	}
+	// Never echo the credentials.
+	a.AuthPass = ""
+	a.STUNPass = ""
+	writeJSON(w, http.StatusCreated, a)
+}
@@ -0,0 +1,82 @@
+package gobaresip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRESTBodyLimit(t *testing.T) {
+	b, mux := newRESTTest()
+	body := `{"aor":"sip:alice@example.com","params":{"x":"` + strings.Repeat("a", restMaxBody) + `"}}`
+
+	w := httptest.NewRecorder()
+	b.wsAuthenticate(mux).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "too large") {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
+
+// restRequest serves a request of a client with perm.
+func restRequest(mux *http.ServeMux, perm WsPermission, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), wsPermKey{}, perm))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestRESTHandlers(t *testing.T) {
+	_, mux := newRESTTest()
+	tests := []struct {
+		perm         WsPermission
+		method, path string
+		body         string
+		code         int
+		contains     string
+	}{
+		{WsObserver, "GET", "/calls", "", http.StatusOK, `"id":"c1"`},
+		{WsObserver, "GET", "/calls/c1", "", http.StatusOK, `"state":"incoming"`},
+		{WsObserver, "GET", "/calls/c2", "", http.StatusNotFound, "call not found"},
+		{WsObserver, "GET", "/calls/c1/unknown", "", http.StatusNotFound, "not found"},
+		{WsObserver, "POST", "/calls", `{"uri":"sip:bob@example.com"}`, http.StatusForbidden, "permission denied"},
+		{WsObserver, "DELETE", "/calls/c1", "", http.StatusForbidden, "permission denied"},
+		{WsOperator, "POST", "/calls", `{"account":"alice"}`, http.StatusBadRequest, "missing uri"},
+		{WsOperator, "POST", "/calls", `{`, http.StatusBadRequest, "invalid request body"},
+		{WsOperator, "POST", "/calls", `{"uri":"sip:bob@example.com"}`, http.StatusServiceUnavailable, ""},
+		{WsOperator, "DELETE", "/calls/c1", "", http.StatusServiceUnavailable, ""},
+		{WsOperator, "POST", "/calls/c1/dtmf", `{}`, http.StatusBadRequest, "missing digits"},
+		{WsObserver, "GET", "/registrations", "", http.StatusOK, "[]"},
+		{WsOperator, "POST", "/accounts", `{}`, http.StatusBadRequest, "missing aor"},
+		{WsOperator, "POST", "/accounts", `{"aor":"sip:a>b@example.com"}`, http.StatusBadRequest, "invalid aor"},
+	}
+	for _, tt := range tests {
+		w := restRequest(mux, tt.perm, tt.method, tt.path, tt.body)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s %s: got %d %s, want %d %q", tt.method, tt.path, w.Code, w.Body, tt.code, tt.contains)
+		}
+	}
+}
+
+func TestWriteCmdError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{&CommandError{Command: "dial", Data: "failed"}, http.StatusUnprocessableEntity},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{ErrCtrlNotConnected, http.StatusServiceUnavailable},
+		{ErrShutdown, http.StatusServiceUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeCmdError(w, tt.err)
+		if w.Code != tt.code {
+			t.Errorf("%v: got %d, want %d", tt.err, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gobaresip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShutdown is returned for commands issued after Shutdown was called.
+var ErrShutdown = errors.New("baresip is shutting down")
+
+// ShutdownError lists the steps of Shutdown which could not complete in time.
+type ShutdownError struct {
+	Steps []string
+}
+
+func (e *ShutdownError) Error() string {
+	return "shutdown incomplete: " + strings.Join(e.Steps, "; ")
+}
+
+func (b *Baresip) shuttingDown() bool {
+	return atomic.LoadUint32(&b.shutdown) == 1
+}
+
+// Shutdown stops a Baresip instance gracefully. It stops accepting commands,
+// hangs up all calls, unregisters all UAs and stops the re main loop, waits
+// for the ctrl_tcp reader, stops the websocket hub and the listeners and
+// finally closes all channels. Steps which don't complete before ctx is done
+// are skipped and reported in a *ShutdownError, the remaining steps still run.
+func (b *Baresip) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&b.shutdown, 0, 1) {
+		return ErrShutdown
+	}
+
+	// Consumers which stopped reading must not hold up the events and
+	// responses the following steps wait for.
+	b.stopBlocking()
+
+	var failed []string
+	running := !b.claimRun()
+
+	if running {
+		if err := b.hangupAll(ctx); err != nil {
+			failed = append(failed, err.Error())
+		}
+
+		// quit unregisters all UAs and stops the main loop once they are done.
+		if err := b.cmd("quit", "", "cmd_quit"); err != nil {
+			failed = append(failed, fmt.Sprintf("unregister: %v", err))
+		} else {
+			select {
+			case <-b.done:
+			case <-ctx.Done():
+				failed = append(failed, "unregister: main loop still running")
+			}
+		}
+	}
+
+	b.stopCtrl()
+
+	if !running {
+		// New already initialised baresip, Run would have torn it down.
+		b.end(0)
+	}
+
+	if running {
+		select {
+		case <-b.readDone:
+		case <-ctx.Done():
+			failed = append(failed, "readers: ctrl_tcp reader still running")
+		}
+	}
+
+	if err := b.shutdownServers(ctx); err != nil {
+		failed = append(failed, fmt.Sprintf("web: %v", err))
+	}
+
+	b.closeOut()
+
+	if len(failed) > 0 {
+		return &ShutdownError{Steps: failed}
+	}
+	return nil
+}
+
+// claimRun keeps Run from starting and reports whether it hadn't started yet.
+// The caller then has to tear down baresip itself.
+func (b *Baresip) claimRun() bool {
+	return atomic.CompareAndSwapUint32(&b.running, 0, 2)
+}
+
+// hangupAll hangs up all calls and waits until baresip reported them closed.
+func (b *Baresip) hangupAll(ctx context.Context) error {
+	for _, c := range b.Calls() {
+		if _, err := b.do(ctx, "hangup", c.ID); err != nil && ctx.Err() != nil {
+			break
+		}
+	}
+
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		n := len(b.Calls())
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return fmt.Errorf("hangup: %d calls still active", n)
+		}
+	}
+}
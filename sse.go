@@ -0,0 +1,130 @@
+package gobaresip
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseClient is a GET /events stream registered at the websocket hub.
+type sseClient struct {
+	send  chan historyEntry
+	sub   EventFilter
+	since uint64
+	// replay is set if the client asked for the events after since.
+	replay bool
+}
+
+// queryList returns all values of key, also comma separated ones.
+func queryList(r *http.Request, key string) []string {
+	var out []string
+	for _, v := range r.URL.Query()[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// serveEvents streams the events as Server-Sent Events. The events can be
+// filtered with the type, class, aor and call_id parameters, each may be
+// repeated or comma separated. A client which sends Last-Event-ID, or the
+// since parameter, first gets the newer events of the history, preceded by
+// a history event with a WsHistory if some of them are lost.
+func (b *Baresip) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	ws := WsSubscription{
+		Types:   queryList(r, "type"),
+		Classes: queryList(r, "class"),
+		AORs:    queryList(r, "aor"),
+		CallIDs: queryList(r, "call_id"),
+	}
+	sub, err := ws.filter()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c := &sseClient{
+		send: make(chan historyEntry, 256+b.hub.history.size),
+		sub:  sub,
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("since")
+	}
+	if last != "" {
+		if c.since, err = strconv.ParseUint(last, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+		c.replay = true
+	}
+
+	select {
+	case b.hub.registerSSE <- c:
+	case <-b.quit:
+		writeError(w, http.StatusServiceUnavailable, ErrStopped.Error())
+		return
+	}
+	defer func() {
+		select {
+		case b.hub.unregisterSSE <- c:
+		case <-b.quit:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("retry: 2000\n\n"))
+	flusher.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	buf := make([]byte, 0, 1024)
+	for {
+		select {
+		case he, ok := <-c.send:
+			if !ok {
+				// Closed by the hub, the client was too slow or Baresip
+				// stopped. It resumes with Last-Event-ID.
+				return
+			}
+			if he.event != "" {
+				buf = append(buf[:0], "event: "+he.event...)
+			} else {
+				buf = append(buf[:0], "id: "...)
+				buf = strconv.AppendUint(buf, he.seq, 10)
+			}
+			buf = append(buf, "\ndata: "...)
+			buf = append(buf, he.e.RawJSON...)
+			buf = append(buf, "\n\n"...)
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
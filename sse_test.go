@@ -0,0 +1,136 @@
+package gobaresip
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeEventsBadRequest(t *testing.T) {
+	b, _ := newRESTTest()
+	tests := []struct {
+		method string
+		target string
+		last   string
+		code   int
+	}{
+		{http.MethodPost, "/events", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/events", "abc", http.StatusBadRequest},
+		{http.MethodGet, "/events?since=-1", "", http.StatusBadRequest},
+		{http.MethodGet, "/events?type=NO_SUCH_EVENT", "", http.StatusBadRequest},
+		// quit is closed, so a valid request can't be registered.
+		{http.MethodGet, "/events?since=1&type=register_ok", "", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.last != "" {
+			r.Header.Set("Last-Event-ID", tt.last)
+		}
+		w := httptest.NewRecorder()
+		b.serveEvents(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s %q: got %d, want %d", tt.method, tt.target, tt.last, w.Code, tt.code)
+		}
+	}
+}
+
+// readSSE returns the next event of an event stream without the trailing
+// blank line.
+func readSSE(r *bufio.Reader) (string, error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line = strings.TrimSuffix(line, "\n"); line == "" {
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestServeEventsReplay(t *testing.T) {
+	events := []EventMsg{
+		{EventType: EventRegisterOK, AccountAOR: "sip:alice@example.com", RawJSON: []byte(`{"type":"REGISTER_OK","accountaor":"sip:alice@example.com"}`)},
+		{EventType: EventCallIncoming, AccountAOR: "sip:alice@example.com", ID: "c1", RawJSON: []byte(`{"type":"CALL_INCOMING","accountaor":"sip:alice@example.com","id":"c1"}`)},
+		{EventType: EventRegisterOK, AccountAOR: "sip:bob@example.com", RawJSON: []byte(`{"type":"REGISTER_OK","accountaor":"sip:bob@example.com"}`)},
+	}
+	tests := []struct {
+		target string
+		last   string
+		want   []string
+	}{
+		{"/events?since=1&type=REGISTER_OK", "", []string{"id: 3"}},
+		{"/events?aor=sip:alice@example.com", "0", []string{"id: 1", "id: 2"}},
+		{"/events?type=call_incoming,register_ok&aor=sip:bob@example.com", "2", []string{"id: 3"}},
+		{"/events?since=10", "", []string{
+			"event: history\ndata: {\"history\":\"reset\",\"since\":10}",
+			"id: 1", "id: 2", "id: 3",
+		}},
+	}
+	for _, tt := range tests {
+		b := newBaresip()
+		b.hub = newWsHub(b)
+		b.eventWsChan = make(chan EventMsg)
+		go b.hub.run()
+		for _, e := range events {
+			b.eventWsChan <- e
+		}
+
+		srv := httptest.NewServer(http.HandlerFunc(b.serveEvents))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+tt.target, nil)
+		if tt.last != "" {
+			r.Header.Set("Last-Event-ID", tt.last)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		br := bufio.NewReader(resp.Body)
+		if s, err := readSSE(br); err != nil || s != "retry: 2000" {
+			t.Errorf("%s: got %q, %v", tt.target, s, err)
+		}
+		for _, want := range tt.want {
+			s, err := readSSE(br)
+			if err != nil {
+				t.Errorf("%s: %v, want %q", tt.target, err, want)
+				break
+			}
+			if s != want && !strings.HasPrefix(s, want+"\n") {
+				t.Errorf("%s: got %q, want %q", tt.target, s, want)
+			}
+		}
+
+		// A live event only reaches the streams it matches.
+		live := EventMsg{EventType: EventRegisterOK, AccountAOR: "sip:bob@example.com", RawJSON: []byte(`{"type":"REGISTER_OK","accountaor":"sip:bob@example.com"}`)}
+		b.eventWsChan <- live
+		wantLive := tt.target != "/events?aor=sip:alice@example.com"
+		got := make(chan string, 1)
+		go func() {
+			s, _ := readSSE(br)
+			got <- s
+		}()
+		select {
+		case s := <-got:
+			if !wantLive || !strings.HasPrefix(s, "id: 4\n") {
+				t.Errorf("%s: got live event %q", tt.target, s)
+			}
+		case <-time.After(50 * time.Millisecond):
+			if wantLive {
+				t.Errorf("%s: live event not sent", tt.target)
+			}
+		}
+
+		cancel()
+		resp.Body.Close()
+		close(b.quit)
+		srv.Close()
+	}
+}
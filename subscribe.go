@@ -0,0 +1,168 @@
+package gobaresip
+
+import "sync"
+
+// OverflowPolicy decides what happens to a message if a buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest buffered message to make room.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest discards the message which doesn't fit anymore.
+	OverflowDropNewest
+	// OverflowBlock waits until there is room or Baresip shuts down. This
+	// stalls the delivery of all following messages.
+	OverflowBlock
+)
+
+// EventFilter selects the events of a subscription. Every non-empty list
+// must contain the corresponding value of an event for it to match.
+// Buffer and Overflow configure the channel of the subscription.
+type EventFilter struct {
+	Types   []EventType
+	Classes []string
+	AORs    []string
+	CallIDs []string
+
+	// Buffer is the channel size, 100 if not set.
+	Buffer int
+	// Overflow is applied when the channel is full.
+	Overflow OverflowPolicy
+}
+
+// Match reports whether e passes the filter.
+func (f EventFilter) Match(e EventMsg) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.EventType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchString(f.Classes, e.Class) &&
+		matchString(f.AORs, e.AccountAOR) &&
+		matchString(f.CallIDs, e.ID)
+}
+
+func matchString(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type subscription struct {
+	filter EventFilter
+	ch     chan EventMsg
+	done   chan struct{}
+	cancel func()
+
+	// mux serializes sends with closing ch.
+	mux sync.Mutex
+}
+
+func (s *subscription) send(e EventMsg, st *queueStats, unblock <-chan struct{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	deliver(eventQueue{s.ch, e}, s.filter.Overflow, st, s.done, unblock)
+}
+
+type subscribers struct {
+	mux  sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+// send delivers e to every matching subscription. Blocked sends give up when
+// unblock is closed.
+func (s *subscribers) send(e EventMsg, st *queueStats, unblock <-chan struct{}) {
+	s.mux.RLock()
+	if len(s.subs) == 0 {
+		s.mux.RUnlock()
+		return
+	}
+	subs := make([]*subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.mux.RUnlock()
+
+	for _, sub := range subs {
+		if sub.filter.Match(e) {
+			sub.send(e, st, unblock)
+		}
+	}
+}
+
+// closeAll cancels all subscriptions.
+func (s *subscribers) closeAll() {
+	s.mux.RLock()
+	subs := make([]*subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.mux.RUnlock()
+
+	for _, sub := range subs {
+		sub.cancel()
+	}
+}
+
+// Subscribe returns a channel which receives all events matching filter.
+// Every subscription has its own buffer, so subscribers don't steal events
+// from each other. The returned cancel func ends the subscription and closes
+// the channel.
+func (b *Baresip) Subscribe(filter EventFilter) (<-chan EventMsg, func()) {
+	size := filter.Buffer
+	if size <= 0 {
+		size = 100
+	}
+	sub := &subscription{
+		filter: filter,
+		ch:     make(chan EventMsg, size),
+		done:   make(chan struct{}),
+	}
+
+	var once sync.Once
+	sub.cancel = func() {
+		once.Do(func() {
+			close(sub.done)
+
+			b.subs.mux.Lock()
+			delete(b.subs.subs, sub)
+			b.subs.mux.Unlock()
+
+			sub.mux.Lock()
+			close(sub.ch)
+			sub.mux.Unlock()
+		})
+	}
+
+	b.outMux.RLock()
+	defer b.outMux.RUnlock()
+	if b.outClosed {
+		sub.cancel()
+		return sub.ch, sub.cancel
+	}
+
+	b.subs.mux.Lock()
+	b.subs.subs[sub] = struct{}{}
+	b.subs.mux.Unlock()
+	return sub.ch, sub.cancel
+}
@@ -0,0 +1,82 @@
+package gobaresip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloseOutWithBlockedSubscriber(t *testing.T) {
+	b := newBaresip()
+	_, cancel := b.Subscribe(EventFilter{Buffer: 1, Overflow: OverflowBlock})
+	defer cancel()
+
+	sent := make(chan struct{})
+	go func() {
+		b.sendEvent(EventMsg{ID: "1"})
+		// Blocks, nobody reads the subscription.
+		b.sendEvent(EventMsg{ID: "2"})
+		close(sent)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		b.closeOut()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closeOut blocked by a subscriber which doesn't read")
+	}
+	<-sent
+}
+
+func TestEventChanOnlyAfterGet(t *testing.T) {
+	b := newBaresip()
+	sub, cancel := b.Subscribe(EventFilter{})
+	defer cancel()
+
+	for i := 0; i < 200; i++ {
+		b.sendEvent(EventMsg{})
+	}
+	if n := len(b.eventChan); n != 0 {
+		t.Fatalf("event chan got %d events before GetEventChan", n)
+	}
+	if n := len(sub); n != 100 {
+		t.Fatalf("subscription got %d events, want 100", n)
+	}
+
+	ch := b.GetEventChan()
+	b.sendEvent(EventMsg{})
+	if n := len(ch); n != 1 {
+		t.Fatalf("event chan got %d events after GetEventChan, want 1", n)
+	}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	e := EventMsg{EventType: EventCallIncoming, Class: "call", AccountAOR: "sip:alice@example.com", ID: "c1"}
+	tests := []struct {
+		name string
+		f    EventFilter
+		want bool
+	}{
+		{"empty", EventFilter{}, true},
+		{"type", EventFilter{Types: []EventType{EventCallClosed, EventCallIncoming}}, true},
+		{"other type", EventFilter{Types: []EventType{EventCallClosed}}, false},
+		{"class", EventFilter{Classes: []string{"call"}}, true},
+		{"other class", EventFilter{Classes: []string{"register"}}, false},
+		{"aor", EventFilter{AORs: []string{"sip:bob@example.com", "sip:alice@example.com"}}, true},
+		{"other aor", EventFilter{AORs: []string{"sip:bob@example.com"}}, false},
+		{"call", EventFilter{CallIDs: []string{"c1"}}, true},
+		{"other call", EventFilter{CallIDs: []string{"c2"}}, false},
+		{"all", EventFilter{Types: []EventType{EventCallIncoming}, Classes: []string{"call"}, CallIDs: []string{"c1"}}, true},
+		{"one mismatch", EventFilter{Types: []EventType{EventCallIncoming}, CallIDs: []string{"c2"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Match(e); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package gobaresip
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// certCheckInterval is how often handshakes check the certificate files.
+const certCheckInterval = 5 * time.Second
+
+// certReloader serves a certificate from files and reloads it once one of the
+// files changed.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mux     sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+	// checking is set while a handshake checks the files.
+	checking uint32
+	onError  func(error)
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) modTimes() (time.Time, time.Time, error) {
+	ci, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	ki, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return ci.ModTime(), ki.ModTime(), nil
+}
+
+func (cr *certReloader) load() error {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mux.Lock()
+	cr.cert = &cert
+	cr.certMod = certMod
+	cr.keyMod = keyMod
+	cr.checked = time.Now()
+	cr.mux.Unlock()
+	return nil
+}
+
+// check reloads the certificate if one of the files changed.
+func (cr *certReloader) check() {
+	cr.mux.Lock()
+	cr.checked = time.Now()
+	oldCert, oldKey := cr.certMod, cr.keyMod
+	cr.mux.Unlock()
+
+	certMod, keyMod, err := cr.modTimes()
+	if err == nil && (!certMod.Equal(oldCert) || !keyMod.Equal(oldKey)) {
+		err = cr.load()
+	}
+	if err != nil && cr.onError != nil {
+		cr.onError(err)
+	}
+}
+
+// getCertificate is used as tls.Config.GetCertificate. At most one handshake
+// every certCheckInterval checks the files, the others don't wait for it. If
+// reloading fails, e.g. because only one of both files was replaced yet, the
+// last certificate is served.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mux.RLock()
+	due := time.Since(cr.checked) >= certCheckInterval
+	cr.mux.RUnlock()
+
+	if due && atomic.CompareAndSwapUint32(&cr.checking, 0, 1) {
+		cr.check()
+		atomic.StoreUint32(&cr.checking, 0)
+	}
+
+	cr.mux.RLock()
+	defer cr.mux.RUnlock()
+	return cr.cert, nil
+}
+
+// wsTLSConfig returns the TLS config for the ws listener or nil for plain
+// HTTP.
+func (b *Baresip) wsTLSConfig() *tls.Config {
+	if b.wsTLS == nil && b.wsCert == nil {
+		return nil
+	}
+
+	var cfg *tls.Config
+	if b.wsTLS != nil {
+		cfg = b.wsTLS.Clone()
+	} else {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if b.wsCert != nil {
+		b.wsCert.onError = func(err error) {
+			b.log(LevelWarn, "reloading ws certificate failed", LogAttr{Key: "error", Value: err})
+		}
+		cfg.Certificates = nil
+		cfg.GetCertificate = b.wsCert.getCertificate
+	}
+	return cfg
+}
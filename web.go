@@ -0,0 +1,82 @@
+package gobaresip
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// WebHandler returns the handler of the websocket UI. It serves the UI on /,
+// the websocket on /ws, the event stream on /events and the REST API described
+// by /openapi.json and can be mounted on any mux, e.g.
+//
+//	mux.Handle("/baresip/", http.StripPrefix("/baresip", b.WebHandler()))
+func (b *Baresip) WebHandler() http.Handler {
+	return b.web
+}
+
+func (b *Baresip) newWebHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.serveRoot)
+	mux.HandleFunc("/ws", b.serveWs)
+	b.registerREST(mux)
+	return b.wsAuthenticate(mux)
+}
+
+// serve binds addr and serves h on it until the servers are closed. If cfg is
+// not nil it serves HTTPS.
+func (b *Baresip) serve(addr string, h http.Handler, cfg *tls.Config) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+
+	srv := &http.Server{Handler: h}
+	b.servers = append(b.servers, srv)
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			b.log(LevelError, err.Error())
+		}
+	}()
+	return nil
+}
+
+// listen starts the listeners which were enabled by options.
+func (b *Baresip) listen() error {
+	if b.wsAddr != "" {
+		if err := b.serve(b.wsAddr, b.web, b.wsTLSConfig()); err != nil {
+			return err
+		}
+	}
+	if b.pprofAddr != "" {
+		if err := b.serve(b.pprofAddr, ProfileHandler(), nil); err != nil {
+			b.closeServers()
+			return err
+		}
+	}
+	return nil
+}
+
+// closeServers closes the listeners immediately.
+func (b *Baresip) closeServers() {
+	for _, srv := range b.servers {
+		srv.Close()
+	}
+}
+
+// shutdownServers stops the listeners and waits for active requests until ctx
+// is done.
+func (b *Baresip) shutdownServers(ctx context.Context) error {
+	var firstErr error
+	for _, srv := range b.servers {
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -2,10 +2,13 @@ package gobaresip
 
 import (
 	"html/template"
-	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"time"
 
+	"github.com/goccy/go-json"
 	"github.com/gorilla/websocket"
 )
 
@@ -27,11 +30,6 @@ var (
 	newline = []byte{'\n'}
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
 // client is a middleman between the websocket connection and the hub.
 type client struct {
 	hub *wsHub
@@ -41,6 +39,30 @@ type client struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// What the client is allowed to do.
+	perm WsPermission
+
+	// Assigned by the hub on register, it tags the tokens of the client's
+	// commands.
+	id uint64
+
+	// The events the client subscribed to, nil for all. Owned by the hub.
+	sub *EventFilter
+
+	// Whether a subscribed client wants the responses to commands of the
+	// application.
+	responses bool
+
+	// The last event the client has seen, it gets the newer events of the
+	// history on register.
+	since uint64
+}
+
+// wsCommand is a command message of a client.
+type wsCommand struct {
+	c   *client
+	msg []byte
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -50,7 +72,10 @@ type client struct {
 // reads from this goroutine.
 func (c *client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.bs.quit:
+		}
 		c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -60,11 +85,15 @@ func (c *client) readPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				c.hub.bs.log(LevelError, err.Error())
 			}
 			break
 		}
-		c.hub.command <- message
+		select {
+		case c.hub.command <- wsCommand{c: c, msg: message}:
+		case <-c.hub.bs.quit:
+			return
+		}
 	}
 }
 
@@ -115,14 +144,27 @@ func (c *client) writePump() {
 }
 
 // serveWs handles websocket requests from the peer.
-func serveWs(hub *wsHub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+func (b *Baresip) serveWs(w http.ResponseWriter, r *http.Request) {
+	conn, err := b.hub.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		b.log(LevelError, err.Error())
+		return
+	}
+	client := &client{
+		hub:  b.hub,
+		conn: conn,
+		send: make(chan []byte, 256+b.hub.history.size),
+		perm: wsPermission(r),
+	}
+	if since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64); err == nil {
+		client.since = since
+	}
+	select {
+	case client.hub.register <- client:
+	case <-b.quit:
+		conn.Close()
 		return
 	}
-	client := &client{hub: hub, conn: conn, send: make(chan []byte, 256)}
-	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
@@ -130,14 +172,27 @@ func serveWs(hub *wsHub, w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
-// wsHub maintains the set of active clients and broadcasts events to the
-// clients.
+// wsHub maintains the set of active clients and routes events and responses
+// to the clients.
 type wsHub struct {
 	// Registered clients.
 	clients map[*client]bool
 
+	// Last assigned client id.
+	seq uint64
+
+	// Recent events for replay.
+	history *eventHistory
+
+	// Registered GET /events streams.
+	sses map[*sseClient]bool
+
+	// Register and unregister requests of GET /events streams.
+	registerSSE   chan *sseClient
+	unregisterSSE chan *sseClient
+
 	// Inbound command from the clients.
-	command chan []byte
+	command chan wsCommand
 
 	// Register requests from the clients.
 	register chan *client
@@ -145,64 +200,259 @@ type wsHub struct {
 	// Unregister requests from clients.
 	unregister chan *client
 
+	// Replies to JSON commands for a single client.
+	reply chan wsCommand
+
+	upgrader websocket.Upgrader
+
 	bs *Baresip
 }
 
 func newWsHub(bs *Baresip) *wsHub {
 	return &wsHub{
 		clients:    make(map[*client]bool),
-		command:    make(chan []byte),
+		command:    make(chan wsCommand),
 		register:   make(chan *client),
 		unregister: make(chan *client),
-		bs:         bs,
+		reply:      make(chan wsCommand),
+		history:    newEventHistory(bs.historySize, bs.historyAge),
+		sses:       make(map[*sseClient]bool),
+
+		registerSSE:   make(chan *sseClient),
+		unregisterSSE: make(chan *sseClient),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     bs.checkOrigin,
+		},
+		bs: bs,
 	}
 }
 
 func (h *wsHub) run() {
 	for {
 		select {
+		case <-h.bs.quit:
+			for client := range h.clients {
+				close(client.send)
+				delete(h.clients, client)
+			}
+			for c := range h.sses {
+				close(c.send)
+				delete(h.sses, c)
+			}
+			return
 		case client := <-h.register:
+			h.seq++
+			client.id = h.seq
 			h.clients[client] = true
+			h.replay(client, client.since)
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
 			}
-		case msg := <-h.command:
-			if err := h.bs.CmdWs(msg); err != nil {
-				log.Println(err)
+		case c := <-h.registerSSE:
+			h.sses[c] = true
+			if c.replay {
+				h.replaySSE(c)
+			}
+		case c := <-h.unregisterSSE:
+			if _, ok := h.sses[c]; ok {
+				delete(h.sses, c)
+				close(c.send)
 			}
+		case cmd := <-h.command:
+			h.handleCommand(cmd)
+		case r := <-h.reply:
+			h.send(r.c, r.msg)
 		case e, ok := <-h.bs.eventWsChan:
 			if !ok {
 				return
 			}
+			he := h.history.add(e)
 			for client := range h.clients {
-				select {
-				case client.send <- e:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if client.sub == nil || client.sub.Match(he.e) {
+					h.send(client, he.e.RawJSON)
+				}
+			}
+			for c := range h.sses {
+				if c.sub.Match(he.e) {
+					h.sendSSE(c, he)
 				}
 			}
 		case r, ok := <-h.bs.responseWsChan:
 			if !ok {
 				return
 			}
-			for client := range h.clients {
-				select {
-				case client.send <- r:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+			h.routeResponse(r)
+		}
+	}
+}
+
+// routeResponse sends a tagged response to the client which issued the
+// command. Responses to commands of the application are only for operators
+// which didn't opt out by subscribing.
+func (h *wsHub) routeResponse(r ResponseMsg) {
+	if id, ok := wsClientID(r.Token); ok {
+		for client := range h.clients {
+			if client.id == id {
+				h.send(client, stampSeq(r.RawJSON, h.history.next()))
+				return
 			}
 		}
+		return
+	}
+	raw := stampSeq(r.RawJSON, h.history.next())
+	for client := range h.clients {
+		if client.perm == WsOperator && (client.sub == nil || client.responses) {
+			h.send(client, raw)
+		}
 	}
 }
 
-func serveRoot(w http.ResponseWriter, r *http.Request) {
-	if err := homeTemplate.Execute(w, "ws://"+r.Host+"/ws"); err != nil {
-		log.Println(err)
+// send queues msg for a registered client. Clients which can't keep up are
+// dropped.
+func (h *wsHub) send(c *client, msg []byte) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		close(c.send)
+		delete(h.clients, c)
+	}
+}
+
+// replay sends the events of the history after seq which match the
+// subscription of a client, after a WsHistory marker if needed.
+func (h *wsHub) replay(c *client, seq uint64) {
+	events, marker := h.history.replay(seq)
+	if marker != nil {
+		msg, err := json.Marshal(marker)
+		if err != nil {
+			h.bs.log(LevelError, err.Error())
+			return
+		}
+		h.send(c, msg)
+	}
+	for _, he := range events {
+		if c.sub == nil || c.sub.Match(he.e) {
+			h.send(c, he.e.RawJSON)
+		}
+	}
+}
+
+// sendSSE queues he for a GET /events stream. Streams which can't keep up are
+// closed and resume with Last-Event-ID.
+func (h *wsHub) sendSSE(c *sseClient, he historyEntry) {
+	select {
+	case c.send <- he:
+	default:
+		close(c.send)
+		delete(h.sses, c)
+	}
+}
+
+// replaySSE sends the events of the history after c.since which match the
+// filter of a GET /events stream, after a history event if needed.
+func (h *wsHub) replaySSE(c *sseClient) {
+	events, marker := h.history.replay(c.since)
+	if marker != nil {
+		msg, err := json.Marshal(marker)
+		if err != nil {
+			h.bs.log(LevelError, err.Error())
+			return
+		}
+		h.sendSSE(c, historyEntry{e: EventMsg{RawJSON: msg}, event: "history"})
+	}
+	for _, he := range events {
+		if _, ok := h.sses[c]; !ok {
+			return
+		}
+		if c.sub.Match(he.e) {
+			h.sendSSE(c, he)
+		}
+	}
+}
+
+// subscribe replaces the subscription of a client. With since the matching
+// events of the history are replayed.
+func (h *wsHub) subscribe(c *client, req WsRequest) {
+	resp := WsResponse{ID: req.ID}
+	if f, err := req.Subscribe.filter(); err != nil {
+		resp.Error = err.Error()
+	} else {
+		c.sub = &f
+		c.responses = req.Subscribe.Responses
+		resp.Ok = true
+	}
+
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		h.bs.log(LevelError, err.Error())
+		return
+	}
+	h.send(c, msg)
+	if resp.Ok && req.Subscribe.Since != nil {
+		h.replay(c, *req.Subscribe.Since)
+	}
+}
+
+// handleCommand runs a command of a client. JSON commands run in their own
+// goroutine, text commands are passed to CmdWs. Either way only the issuing
+// client gets the response.
+func (h *wsHub) handleCommand(cmd wsCommand) {
+	if req, ok, err := parseWsRequest(cmd.msg); ok {
+		if err == nil && req.Subscribe != nil && req.Command == "" {
+			h.subscribe(cmd.c, req)
+			return
+		}
+		go func() {
+			resp := WsResponse{ID: req.ID, Error: "invalid request"}
+			if err == nil {
+				resp = h.bs.doWs(req, cmd.c.perm)
+			}
+			msg, err := json.Marshal(resp)
+			if err != nil {
+				h.bs.log(LevelError, err.Error())
+				return
+			}
+			select {
+			case h.reply <- wsCommand{c: cmd.c, msg: msg}:
+			case <-h.bs.quit:
+			}
+		}()
+		return
+	}
+
+	if cmd.c.perm != WsOperator {
+		h.send(cmd.c, wsDenied(cmd.msg))
+		return
+	}
+	if err := h.bs.cmdWs(cmd.msg, wsTag(cmd.c.id)); err != nil {
+		h.bs.log(LevelError, err.Error())
+	}
+}
+
+func (b *Baresip) serveRoot(w http.ResponseWriter, r *http.Request) {
+	// Resolve /ws against the original path, WebHandler may be mounted below a
+	// prefix.
+	p := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		p = u.Path
+	}
+	ws := path.Join(path.Dir(p+"x"), "ws")
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		ws += "?access_token=" + url.QueryEscape(token)
+	}
+	scheme := "ws://"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "wss://"
+	}
+	if err := homeTemplate.Execute(w, scheme+r.Host+ws); err != nil {
+		b.log(LevelError, err.Error())
 	}
 }
 
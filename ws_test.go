@@ -0,0 +1,63 @@
+package gobaresip
+
+import (
+	"testing"
+)
+
+func newTestClient(h *wsHub, id uint64, perm WsPermission) *client {
+	c := &client{hub: h, send: make(chan []byte, 10), perm: perm, id: id}
+	h.clients[c] = true
+	return c
+}
+
+func TestHandleResponseSkipsWsForDo(t *testing.T) {
+	b := newBaresip()
+	b.responseWsChan = make(chan ResponseMsg, 10)
+
+	token, ch := b.requests.add("gobaresip_nonce")
+	b.handleResponse([]byte(`{"response":true,"ok":true,"data":"secret","token":"` + token + `"}`))
+
+	if r := <-ch; r.Data != "secret" {
+		t.Fatalf("Do got %q", r.Data)
+	}
+	if n := len(b.responseWsChan); n != 0 {
+		t.Fatalf("response of Do was sent to the websocket hub")
+	}
+}
+
+func TestRouteResponse(t *testing.T) {
+	b := newBaresip()
+	h := newWsHub(b)
+	owner := newTestClient(h, 1, WsOperator)
+	other := newTestClient(h, 2, WsOperator)
+	observer := newTestClient(h, 3, WsObserver)
+	subscribed := newTestClient(h, 4, WsOperator)
+	subscribed.sub = &EventFilter{}
+
+	h.routeResponse(ResponseMsg{Token: "cmd_dial" + wsTag(1), RawJSON: []byte("own")})
+	if len(owner.send) != 1 || len(other.send) != 0 || len(observer.send) != 0 || len(subscribed.send) != 0 {
+		t.Fatalf("tagged response not only sent to its issuer")
+	}
+
+	h.routeResponse(ResponseMsg{Token: "cmd_dial", RawJSON: []byte("app")})
+	if len(other.send) != 1 || len(observer.send) != 0 || len(subscribed.send) != 0 {
+		t.Fatalf("application response sent to observer or subscribed client")
+	}
+}
+
+func TestHandleResponseDropsLateDo(t *testing.T) {
+	b := newBaresip()
+	b.responseWsChan = make(chan ResponseMsg, 10)
+
+	token, _ := b.requests.add("dial")
+	b.requests.remove(token)
+	b.handleResponse([]byte(`{"response":true,"ok":true,"data":"late","token":"` + token + `"}`))
+	if len(b.responseChan) != 0 || len(b.responseWsChan) != 0 {
+		t.Fatal("late response of Do was delivered")
+	}
+
+	b.handleResponse([]byte(`{"response":true,"ok":true,"token":"cmd_dial#3"}`))
+	if len(b.responseChan) != 1 || len(b.responseWsChan) != 1 {
+		t.Fatal("response of Cmd was not delivered")
+	}
+}
@@ -0,0 +1,136 @@
+package gobaresip
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// WsPermission is what a websocket client is allowed to do.
+type WsPermission int
+
+const (
+	// WsObserver receives events and responses but can't issue commands.
+	WsObserver WsPermission = iota
+	// WsOperator may also issue commands.
+	WsOperator
+)
+
+func (p WsPermission) String() string {
+	switch p {
+	case WsObserver:
+		return "observer"
+	case WsOperator:
+		return "operator"
+	}
+	return "unknown"
+}
+
+// WsAuthFunc authenticates a request to the web UI or the websocket. It
+// returns the permission of the client and whether the request is
+// authenticated.
+type WsAuthFunc func(r *http.Request) (WsPermission, bool)
+
+type wsPermKey struct{}
+
+// wsBearerAuth accepts the token from the Authorization header or, as
+// browsers can't set headers on websockets, from the access_token parameter.
+func wsBearerAuth(token string, perm WsPermission) WsAuthFunc {
+	return func(r *http.Request) (WsPermission, bool) {
+		got := r.URL.Query().Get("access_token")
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			got = strings.TrimPrefix(h, "Bearer ")
+		}
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return perm, false
+		}
+		return perm, true
+	}
+}
+
+func wsBasicAuth(user, pass string, perm WsPermission) WsAuthFunc {
+	return func(r *http.Request) (WsPermission, bool) {
+		u, p, ok := r.BasicAuth()
+		if !ok {
+			return perm, false
+		}
+		uok := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		pok := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		return perm, uok && pok
+	}
+}
+
+// wsAuthenticate rejects requests which no authenticator accepts. Without
+// authenticators every client is an operator.
+func (b *Baresip) wsAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perm := WsOperator
+		if len(b.wsAuth) > 0 {
+			ok := false
+			for _, auth := range b.wsAuth {
+				if perm, ok = auth(r); ok {
+					break
+				}
+			}
+			if !ok {
+				if b.wsBasic {
+					w.Header().Set("WWW-Authenticate", `Basic realm="`+b.userAgent+`"`)
+				}
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), wsPermKey{}, perm)))
+	})
+}
+
+func wsPermission(r *http.Request) WsPermission {
+	if perm, ok := r.Context().Value(wsPermKey{}).(WsPermission); ok {
+		return perm
+	}
+	return WsObserver
+}
+
+// checkOrigin accepts requests without Origin header, which don't come from a
+// browser. Without allowlist the origin must match the host like gorilla's
+// default check does.
+func (b *Baresip) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(b.wsOrigins) == 0 {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, o := range b.wsOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsDenied returns the response for a command of a client without permission.
+func wsDenied(raw []byte) []byte {
+	command := ""
+	if f := bytes.Fields(raw); len(f) > 0 {
+		command = strings.ToLower(string(f[0]))
+	}
+	msg, _ := json.Marshal(ResponseMsg{
+		Response: true,
+		Ok:       false,
+		Data:     "permission denied",
+		Token:    "cmd_" + command,
+	})
+	return msg
+}
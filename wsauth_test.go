@@ -0,0 +1,34 @@
+package gobaresip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{nil, "", true},
+		{nil, "http://example.com", true},
+		{nil, "http://EXAMPLE.com", true},
+		{nil, "http://evil.com", false},
+		{nil, "://", false},
+		{[]string{"https://app.example.org/"}, "https://app.example.org", true},
+		{[]string{"https://app.example.org"}, "http://example.com", false},
+		{[]string{"*"}, "http://evil.com", true},
+	}
+	for _, tt := range tests {
+		b := newBaresip()
+		b.wsOrigins = tt.origins
+		r := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := b.checkOrigin(r); got != tt.want {
+			t.Errorf("origins %v, origin %q: got %v, want %v", tt.origins, tt.origin, got, tt.want)
+		}
+	}
+}
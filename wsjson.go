@@ -0,0 +1,169 @@
+package gobaresip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// wsCmdTimeout limits how long a JSON command of a websocket client may take.
+const wsCmdTimeout = 30 * time.Second
+
+// WsRequest is a command of a websocket client in JSON mode, e.g.
+//
+//	{"id":1,"command":"dial","params":"sip:alice@example.com"}
+//
+// Clients which send plain text lines use the legacy mode of CmdWs instead.
+//
+// A request without command but with subscribe replaces the subscription of
+// the client, e.g.
+//
+//	{"id":2,"subscribe":{"types":["CALL_INCOMING","CALL_CLOSED"]}}
+type WsRequest struct {
+	ID        json.RawMessage `json:"id,omitempty"`
+	Command   string          `json:"command,omitempty"`
+	Params    string          `json:"params,omitempty"`
+	Subscribe *WsSubscription `json:"subscribe,omitempty"`
+}
+
+// WsSubscription selects what a websocket client receives. Clients without
+// subscription receive all events and, if they are operators, the responses
+// to the text commands of the application. Empty lists match all events,
+// responses to the client's own commands are always delivered. Responses to
+// Do are never sent to websocket clients.
+type WsSubscription struct {
+	Types   []string `json:"types,omitempty"`
+	Classes []string `json:"classes,omitempty"`
+	AORs    []string `json:"aors,omitempty"`
+	CallIDs []string `json:"call_ids,omitempty"`
+
+	// Responses also delivers the responses to the text commands of the
+	// application to operators.
+	Responses bool `json:"responses,omitempty"`
+
+	// Since replays the matching events of the history after this sequence
+	// number. A WsHistory message is sent first if not all of them are kept.
+	Since *uint64 `json:"since,omitempty"`
+}
+
+// The History values of WsHistory.
+const (
+	// HistoryGap means that events after Since were dropped from the history.
+	HistoryGap = "gap"
+	// HistoryReset means that Since is unknown, e.g. from a previous
+	// instance, and all kept events are replayed.
+	HistoryReset = "reset"
+)
+
+// WsHistory is sent before a replay which can't continue seamlessly after
+// Since. The client should resync its state, e.g. with the REST API.
+type WsHistory struct {
+	History string `json:"history"`
+	Since   uint64 `json:"since"`
+}
+
+func (s *WsSubscription) filter() (EventFilter, error) {
+	f := EventFilter{Classes: s.Classes, AORs: s.AORs, CallIDs: s.CallIDs}
+	for _, t := range s.Types {
+		et, err := ParseEventType(strings.ToUpper(t))
+		if err != nil {
+			return f, err
+		}
+		f.Types = append(f.Types, et)
+	}
+	return f, nil
+}
+
+// wsTag returns the token suffix for the commands of client id.
+func wsTag(id uint64) string {
+	return "#ws" + strconv.FormatUint(id, 10)
+}
+
+// wsClientID returns the client id of a token tagged by wsTag.
+func wsClientID(token string) (uint64, bool) {
+	i := strings.LastIndex(token, "#ws")
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(token[i+3:], 10, 64)
+	return id, err == nil
+}
+
+// WsResponse answers a WsRequest. ID is copied from the request and Token is
+// the ctrl_tcp token of the command.
+type WsResponse struct {
+	ID    json.RawMessage `json:"id,omitempty"`
+	Ok    bool            `json:"ok"`
+	Data  string          `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Token string          `json:"token,omitempty"`
+}
+
+var (
+	errWsDenied     = errors.New("permission denied")
+	errWsNotAllowed = errors.New("command not allowed")
+	errWsNoCommand  = errors.New("missing command")
+)
+
+// parseWsRequest reports whether msg is a JSON command. Invalid JSON is still
+// a JSON command which is answered with the error.
+func parseWsRequest(msg []byte) (WsRequest, bool, error) {
+	var req WsRequest
+	msg = bytes.TrimSpace(msg)
+	if len(msg) == 0 || msg[0] != '{' {
+		return req, false, nil
+	}
+	err := json.Unmarshal(msg, &req)
+	return req, true, err
+}
+
+// doWs runs a JSON command of a websocket client and returns its response.
+func (b *Baresip) doWs(req WsRequest, perm WsPermission) WsResponse {
+	resp := WsResponse{ID: req.ID}
+	command := strings.ToLower(strings.TrimSpace(req.Command))
+
+	var err error
+	var r ResponseMsg
+	switch {
+	case command == "":
+		err = errWsNoCommand
+	case perm != WsOperator:
+		err = errWsDenied
+	case command == "quit" || command == "uadelall":
+		err = errWsNotAllowed
+	default:
+		ctx, cancel := b.quitContext()
+		defer cancel()
+		ctx, tcancel := context.WithTimeout(ctx, wsCmdTimeout)
+		defer tcancel()
+
+		switch command {
+		case "autodialadd":
+			b.autodialAdd(req.Params)
+			command = "autodialinfo"
+		case "autodialdel":
+			b.autodialDel(req.Params)
+			command = "autodialinfo"
+		case "autohangupgap":
+			b.autohangupGap(req.Params)
+			command = "autodialinfo"
+		case "autocmdinfo":
+			command = "autodialinfo"
+		}
+		r, err = b.Do(ctx, command, req.Params)
+	}
+
+	resp.Token = r.Token
+	resp.Data = r.Data
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Ok = true
+	return resp
+}
@@ -0,0 +1,115 @@
+package gobaresip
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestWsClientID(t *testing.T) {
+	tests := []struct {
+		token string
+		id    uint64
+		ok    bool
+	}{
+		{"cmd_dial" + wsTag(7), 7, true},
+		{"cmd_dial#3" + wsTag(12), 12, true},
+		{"cmd_dial#3", 0, false},
+		{"cmd_dial#ws", 0, false},
+		{"cmd_dial#wsx", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := wsClientID(tt.token)
+		if ok != tt.ok || (ok && id != tt.id) {
+			t.Errorf("wsClientID(%q) = %d, %v, want %d, %v", tt.token, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestParseWsRequest(t *testing.T) {
+	tests := []struct {
+		msg     string
+		command string
+		json    bool
+		err     bool
+	}{
+		{"dial sip:alice@example.com", "", false, false},
+		{"", "", false, false},
+		{` {"id":1,"command":"dial","params":"sip:alice@example.com"}`, "dial", true, false},
+		{`{"id":1,"command":`, "", true, true},
+	}
+	for _, tt := range tests {
+		req, ok, err := parseWsRequest([]byte(tt.msg))
+		if ok != tt.json || (err != nil) != tt.err || req.Command != tt.command {
+			t.Errorf("%q: got %+v, %v, %v", tt.msg, req, ok, err)
+		}
+	}
+}
+
+// answerCtrl answers every command written to conn with ok and its params.
+func answerCtrl(b *Baresip, conn net.Conn) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		msg := buf[:n]
+		if i := bytes.IndexByte(msg, ':'); i >= 0 {
+			msg = bytes.TrimSuffix(msg[i+1:], []byte(","))
+		}
+		var cmd struct {
+			Params string `json:"params"`
+			Token  string `json:"token"`
+		}
+		if err := json.Unmarshal(msg, &cmd); err != nil {
+			return
+		}
+		resp, _ := json.Marshal(ResponseMsg{Response: true, Ok: true, Data: cmd.Params, Token: cmd.Token})
+		b.handleResponse(resp)
+	}
+}
+
+func TestDoWs(t *testing.T) {
+	b := newBaresip()
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	b.ctrlConn = conn
+	b.ctrlConnAlive = 1
+	go answerCtrl(b, peer)
+
+	tests := []struct {
+		perm    WsPermission
+		req     string
+		ok      bool
+		data    string
+		errText string
+	}{
+		{WsObserver, `{"id":1,"command":"dial","params":"sip:bob@example.com"}`, false, "", errWsDenied.Error()},
+		{WsOperator, `{"id":"a","command":"quit"}`, false, "", errWsNotAllowed.Error()},
+		{WsOperator, `{"id":2,"command":" UADELALL "}`, false, "", errWsNotAllowed.Error()},
+		{WsOperator, `{"id":3,"params":"sip:bob@example.com"}`, false, "", errWsNoCommand.Error()},
+		{WsObserver, `{"id":4}`, false, "", errWsNoCommand.Error()},
+		{WsOperator, `{"id":{"n":5},"command":"dial","params":"sip:bob@example.com"}`, true, "sip:bob@example.com", ""},
+	}
+	for _, tt := range tests {
+		req, _, err := parseWsRequest([]byte(tt.req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := b.doWs(req, tt.perm)
+		if !bytes.Equal(resp.ID, req.ID) {
+			t.Errorf("%s: got id %s", tt.req, resp.ID)
+		}
+		if resp.Ok != tt.ok || resp.Data != tt.data || resp.Error != tt.errText {
+			t.Errorf("%s: got %+v", tt.req, resp)
+		}
+		if tt.ok && !isDoToken(resp.Token) {
+			t.Errorf("%s: got token %q", tt.req, resp.Token)
+		}
+	}
+}